@@ -0,0 +1,32 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+// commandFormats are output formats which print a command that sets up
+// the connection on another machine or device. They are written to stdout
+// unless -o is given.
+var commandFormats = map[string]func(nm2qr.NetworkSetting) (string, error){
+	"nmcli": nm2qr.NmcliCommand,
+	"iwctl": nm2qr.IwctlCommand,
+	"adb":   nm2qr.AdbCommand,
+}
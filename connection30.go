@@ -19,67 +19,216 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/godbus/dbus"
 	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
 	ux "github.com/pseyfert/go-networkmanager-qrcode-generator/ux"
 )
 
 func validformat(s string) bool {
-	return s == "png" || s == "plain" || s == "string"
+	_, isProfile := profileFormats[s]
+	_, isCommand := commandFormats[s]
+	return s == "png" || s == "plain" || s == "string" || s == "show" || isProfile || isCommand
 }
 
-func main() {
-	dbusConnection, err := dbus.SystemBus()
-	if err != nil {
-		fmt.Printf("ERROR: couldn't connect to system dbus\n")
-		os.Exit(9)
+// qrBackend refuses 802.1x connections before their password is fetched,
+// a WIFI: code cannot describe them. Configuration profiles can.
+type qrBackend struct {
+	ux.Backend
+}
+
+func (b qrBackend) FetchSecrets(ctx context.Context, ns *nm2qr.NetworkSetting) error {
+	if ns.IsEap() {
+		return fmt.Errorf("%w: a QR code cannot describe the 802.1x connection %s, use a configuration profile format", nm2qr.ErrUnsupportedType, ns.Id)
 	}
+	return b.Backend.FetchSecrets(ctx, ns)
+}
 
+func main() {
 	var outputname string
 	var connectionId int
 	var connectionName string
 	var format string
 	var exactMatch bool
 	var listConnections bool
-	flag.StringVar(&outputname, "o", "network.png", "output filename")
-	flag.StringVar(&format, "f", "png", "output format (allowed: png, string, plain)")
+	var listAll bool
+	var jsonOutput bool
+	var withSecret bool
+	var timeout time.Duration
+	var backendSpec string
+	var dumpConnections bool
+	var connectionType string
+	var dppUri string
+	var dppKeyfile string
+	var dppInfo string
+	var dppChannels string
+	var newUUID bool
+	var rpiBoot string
+	var country string
+	flag.StringVar(&outputname, "o", "network.png", "output filename (network.EXT for configuration files, the profile name iwd expects for iwd, - for stdout, stdout by default for commands)")
+	flag.StringVar(&format, "f", "png", "output format (allowed: png, string, plain, show, mobileconfig, wlanprofile, onc, keyfile, wpasupplicant, iwd, netplan, nmcli, iwctl, adb)")
 	flag.IntVar(&connectionId, "i", -1, "network manager connection Id to visualize")
 	flag.StringVar(&connectionName, "n", "", "network manager connection name to visualize")
 	flag.BoolVar(&exactMatch, "e", false, "matches by name must be exact (fuzzy by default)")
 	flag.BoolVar(&listConnections, "l", false, "list connection names and quit")
+	flag.BoolVar(&listAll, "all", false, "with -l, also list skipped connections and the reason")
+	flag.StringVar(&connectionType, "type", "", "with -l, list connections of this type (e.g. wifi, wireguard, ethernet, 802-3-ethernet) or of any type, instead of those a QR code can be made for")
+	flag.BoolVar(&jsonOutput, "json", false, "print the connection (or with -l the connection list) as JSON")
+	flag.BoolVar(&withSecret, "secret", false, "include the secret in JSON output (the payload without secret otherwise), the secret and payload in show output")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "give up on NetworkManager after this time")
+	flag.BoolVar(&newUUID, "new-uuid", false, "give the connection a new UUID in configuration files (default keep it)")
+	flag.StringVar(&rpiBoot, "rpi-boot", "", "write the files for headless Wi-Fi of a Raspberry Pi into this boot partition directory instead")
+	flag.StringVar(&country, "country", "", "with -rpi-boot, the country code of the regulatory domain (e.g. DE)")
+	flag.StringVar(&backendSpec, "backend", "networkmanager", "where connections are read from (networkmanager, replay:FILE, mobileconfig:FILE, wlanprofile:FILE, onc:FILE, netplan:FILE, hostapd:FILE, openwrt:FILE)")
+	flag.StringVar(&dppUri, "dpp", "", "validate the DPP: URI of a device, print its fields and quit")
+	flag.StringVar(&dppKeyfile, "dpp-keygen", "", "create a DPP bootstrapping key, store the private key in this file and output the DPP: URI")
+	flag.StringVar(&dppInfo, "dpp-info", "", "with -dpp-keygen, information (I:) to put in the URI")
+	flag.StringVar(&dppChannels, "dpp-channels", "", "with -dpp-keygen, channels (C:) to put in the URI, e.g. 81/1,81/6")
+	flag.BoolVar(&dumpConnections, "dump", false, "record what NetworkManager returns for the connection given with -i (default all) as JSON fixture and quit")
 
 	flag.Parse()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	if !validformat(format) {
-		fmt.Printf("ERROR: invalid format requested: %s\n", format)
-		os.Exit(8)
+		fail(exitBadFormat, "invalid format requested: %s", format)
+	}
+	outputSet := false
+	flag.Visit(func(f *flag.Flag) { outputSet = outputSet || f.Name == "o" })
+	if profile, isProfile := profileFormats[format]; isProfile && !outputSet {
+		outputname = "network" + profile.extension
+	}
+	if rpiBoot != "" {
+		if !nm2qr.IsCountryCode(country) {
+			fail(exitUsage, "-rpi-boot needs the country code of the regulatory domain, e.g. -country DE")
+		}
+		if info, err := os.Stat(rpiBoot); nil != err || !info.IsDir() {
+			fail(exitUsage, "%s is not a directory", rpiBoot)
+		}
+	}
+	if dppUri != "" {
+		showDPP(dppUri, jsonOutput)
+		os.Exit(exitOK)
+	}
+	if dppKeyfile != "" {
+		keygenDPP(dppKeyfile, dppInfo, dppChannels, format, outputname)
+		os.Exit(exitOK)
+	}
+	backend, err := ux.OpenBackend(backendSpec)
+	if err != nil {
+		if errors.Is(err, ux.ErrUnknownBackend) {
+			fail(exitUsage, "%v", err)
+		} else if backendSpec == "networkmanager" {
+			fail(exitDbus, "%v", err)
+		}
+		fail(exitInput, "%v", err)
+	}
+	if dumpConnections {
+		var ids []int
+		if connectionId >= 0 {
+			ids = append(ids, connectionId)
+		}
+		dump(ctx, backend, ids, withSecret)
+		os.Exit(exitOK)
 	}
 	if listConnections {
-		fmt.Printf("the following connections are known:\n")
-		cons, err := ux.AllConnections(dbusConnection)
+		if !jsonOutput {
+			fmt.Printf("the following connections are known:\n")
+		}
+		cons, err := ux.AllConnectionsFrom(ctx, backend)
 		if err != nil {
-			fmt.Printf("ERROR: %v\n", err)
-			os.Exit(8)
+			fail(exitCode(err, exitListing), "%v", err)
+		}
+		listed := func(ns nm2qr.NetworkSetting) bool {
+			if connectionType == "" {
+				return ns.CheckType() == nil
+			}
+			return connectionType == "any" || nm2qr.TypeName(connectionType) == ns.TypeName()
+		}
+		if jsonOutput {
+			list := make([]jsonConnection, 0, len(cons.Settings))
+			denied := false
+			for _, con := range cons.Settings {
+				if !listed(con) {
+					continue
+				}
+				if withSecret {
+					// secrets are only retrieved when asked for
+					if err := backend.FetchSecrets(ctx, &con); err != nil {
+						fmt.Fprintf(os.Stderr, "%v\n", err)
+					}
+					denied = denied || con.PermissionDenied
+				}
+				list = append(list, newJsonConnection(con, withSecret))
+			}
+			for _, con := range cons.Others {
+				if listed(con) {
+					list = append(list, newJsonOther(con))
+				}
+			}
+			if listAll {
+				for _, f := range cons.Errors {
+					list = append(list, newJsonSkipped(f))
+				}
+			}
+			if denied {
+				fmt.Fprintf(os.Stderr, "%s\n", nm2qr.PolkitHint)
+			}
+			if err := writeJson(os.Stdout, list); err != nil {
+				fail(exitOutput, "%v", err)
+			}
+			os.Exit(exitOK)
+		}
+		for _, con := range cons.Settings {
+			if listed(con) && con.IsWireGuard() {
+				fmt.Printf("%s:\t%s\n", con.Id, con.TypeName())
+			} else if listed(con) {
+				fmt.Printf("%s:\tSSID %s\n", con.Id, con.Ssid)
+			}
+		}
+		var unsupported []nm2qr.NetworkSetting
+		for _, con := range cons.Others {
+			if listed(con) {
+				fmt.Printf("%s:\t%s\n", con.Id, con.TypeName())
+			} else if con.Unsupported != "" {
+				unsupported = append(unsupported, con)
+			}
 		}
-		for _, con := range cons {
-			fmt.Printf("%s:\tSSID %s\n", con.Id, con.Ssid)
+		if listAll && len(cons.Errors)+len(unsupported) > 0 {
+			fmt.Printf("the following connections were skipped:\n")
+			for _, con := range unsupported {
+				fmt.Printf("%s:\t%v\n", con.Id, con.CheckType())
+			}
+			for _, f := range cons.Errors {
+				name := f.Id
+				if name == "" {
+					name = string(f.Path)
+				}
+				fmt.Printf("%s:\t%v\n", name, f.Err)
+			}
+		} else if len(cons.Errors)+len(unsupported) > 0 {
+			fmt.Fprintf(os.Stderr, "%d connections skipped, use -all to see why\n", len(cons.Errors)+len(unsupported))
 		}
-		os.Exit(0)
+		os.Exit(exitOK)
 	}
 	if connectionId < 0 && connectionName == "" {
-		fmt.Printf("ERROR: specify either a connection ID or a connection name")
-		os.Exit(7)
+		fail(exitUsage, "specify either a connection ID or a connection name")
+	}
+
+	if (format == "png" || format == "plain" || format == "string") && !jsonOutput && rpiBoot == "" {
+		backend = qrBackend{backend}
 	}
 
 	var networkSettings nm2qr.NetworkSetting
 	if connectionId >= 0 {
-		ids, err := ux.ConnectionIDs(dbusConnection)
+		ids, err := backend.ConnectionIDs(ctx)
 		if nil != err {
-			fmt.Printf("could not obtain list of connections: %v\n", err)
-			fmt.Print("continuing\n")
+			fmt.Fprintf(os.Stderr, "could not obtain list of connections: %v\n", err)
+			fmt.Fprint(os.Stderr, "continuing\n")
 		} else {
 			found := false
 			for _, id := range ids {
@@ -89,45 +238,114 @@ func main() {
 				}
 			}
 			if !found {
-				fmt.Printf("%d is not in the list of known connections. trying anyway.\n", connectionId)
+				fmt.Fprintf(os.Stderr, "%d is not in the list of known connections. trying anyway.\n", connectionId)
 			}
-			networkSettings, err = nm2qr.GetNetworkSettings(connectionId, dbusConnection)
 		}
+		networkSettings, err = ux.NetworkSettingFrom(ctx, backend, connectionId)
+	} else if exactMatch {
+		networkSettings, err = ux.ExactMatchFrom(ctx, connectionName, backend)
 	} else {
-		networkSettings, err = ux.BestMatch(connectionName, dbusConnection)
-		if exactMatch && !(networkSettings.Id == connectionName || string(networkSettings.Ssid) == connectionName) {
-			fmt.Printf("%s is not in the list of known connections.\n", connectionName)
-			os.Exit(3)
-		}
+		networkSettings, err = ux.BestMatchFrom(ctx, connectionName, backend)
 	}
 
+	if errors.Is(err, nm2qr.ErrPermissionDenied) {
+		fmt.Fprintf(os.Stderr, "%s\n", nm2qr.PolkitHint)
+	}
+	if format == "show" && (errors.Is(err, nm2qr.ErrNoSecret) || errors.Is(err, nm2qr.ErrPermissionDenied)) {
+		// the settings are complete, show them without asking for the secret
+		if err := writeShow(os.Stdout, networkSettings, withSecret, err); err != nil {
+			fail(exitOutput, "%v", err)
+		}
+		os.Exit(exitOK)
+	}
+	if (errors.Is(err, nm2qr.ErrNoSecret) || errors.Is(err, nm2qr.ErrPermissionDenied)) && networkSettings.IsPsk {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		// keep the original error if prompting is impossible
+		if perr := ux.PromptKey(&networkSettings); nil == perr || errors.Is(perr, nm2qr.ErrInvalidKey) {
+			err = perr
+		}
+	}
+	if errors.Is(err, nm2qr.ErrUnsupportedType) {
+		fail(exitUnsupportedType, "%v", err)
+	}
 	if nil != err {
-		fmt.Printf("something went wrong in network setting retrival, %v\n", err)
-		os.Exit(1)
+		fail(exitCode(err, exitFailure), "something went wrong in network setting retrival, %v", err)
 	}
+	if networkSettings.KeySource != nm2qr.SecretSourceNone {
+		fmt.Fprintf(os.Stderr, "secret obtained from %v\n", networkSettings.KeySource)
+	}
+
+	if rpiBoot != "" {
+		files, err := nm2qr.RaspberryPiBoot(networkSettings, country)
+		if nil != err {
+			fail(exitCode(err, exitFailure), "%v", err)
+		}
+		if err := writeFiles(rpiBoot, files); nil != err {
+			fail(exitOutput, "%v", err)
+		}
+	} else if jsonOutput {
+		if err := writeJson(os.Stdout, newJsonConnection(networkSettings, withSecret)); err != nil {
+			fail(exitOutput, "%v", err)
+		}
+	} else if format == "show" {
+		if err := writeShow(os.Stdout, networkSettings, withSecret, nil); err != nil {
+			fail(exitOutput, "%v", err)
+		}
+	} else if profile, isProfile := profileFormats[format]; isProfile {
+		if newUUID {
+			uuid, err := nm2qr.NewUUID()
+			if nil != err {
+				fail(exitFailure, "%v", err)
+			}
+			networkSettings.Uuid, networkSettings.Connection.Uuid = uuid, uuid
+		}
+		if nil != profile.filename && !outputSet {
+			outputname = profile.filename(networkSettings)
+		}
+		data, err := profile.render(networkSettings)
+		if nil != err {
+			fail(exitCode(err, exitFailure), "%v", err)
+		}
+		if err := writeProfile(data, outputname); nil != err {
+			fail(exitOutput, "%v", err)
+		}
+	} else if command, isCommand := commandFormats[format]; isCommand {
+		line, err := command(networkSettings)
+		if nil != err {
+			fail(exitCode(err, exitFailure), "%v", err)
+		}
+		if !outputSet {
+			outputname = "-"
+		}
+		if err := writeProfile([]byte(line+"\n"), outputname); nil != err {
+			fail(exitOutput, "%v", err)
+		}
+	} else {
+		writeCode(nm2qr.Payload(networkSettings), format, outputname)
+	}
+}
 
+// writeCode outputs a QR code payload in one of the formats png, string
+// and plain.
+func writeCode(payload, format, outputname string) {
 	if format == "plain" {
-		qr := nm2qr.NetworkCode(networkSettings)
-		fmt.Printf("QR code should contain:\n%s\n", qr)
+		fmt.Printf("QR code should contain:\n%s\n", payload)
 	} else if format == "string" {
-		qr, err := nm2qr.QRNetworkCode(networkSettings)
+		qr, err := nm2qr.QRCode(payload)
 		if nil != err {
-			fmt.Printf("something went wrong in qr code generation, %v\n", err)
-			os.Exit(2)
+			fail(exitQRGeneration, "something went wrong in qr code generation, %v", err)
 		}
 		// fmt.Printf("QR code as string:\n%s\n", nm2qr.CompressQR(qr.ToString(false)))
 		fmt.Printf("QR code as string:\n%s\n", qr.ToString(false))
 		fmt.Printf("QR code as string:\n%s\n", qr.ToSmallString(false))
 	} else {
-		qr, err := nm2qr.QRNetworkCode(networkSettings)
+		qr, err := nm2qr.QRCode(payload)
 		if nil != err {
-			fmt.Printf("something went wrong in qr code generation, %v\n", err)
-			os.Exit(2)
+			fail(exitQRGeneration, "something went wrong in qr code generation, %v", err)
 		}
 		err = qr.WriteFile(-5, outputname)
 		if nil != err {
-			fmt.Printf("something went wrong in qr code storing, %v\n", err)
-			os.Exit(3)
+			fail(exitOutput, "something went wrong in qr code storing, %v", err)
 		}
 	}
 }
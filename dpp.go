@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+// jsonDPP is the machine readable representation of a DPP URI.
+type jsonDPP struct {
+	Channels  []string `json:"channels,omitempty"`
+	Mac       string   `json:"mac,omitempty"`
+	Info      string   `json:"info,omitempty"`
+	Version   int      `json:"version,omitempty"`
+	Host      string   `json:"host,omitempty"`
+	PublicKey string   `json:"public_key"`
+	Curve     string   `json:"curve"`
+	Uri       string   `json:"uri"`
+}
+
+// showDPP validates the DPP URI of a device and prints its fields.
+func showDPP(uri string, jsonOutput bool) {
+	u, err := nm2qr.ParseDPPURI(strings.TrimSpace(uri))
+	if nil != err {
+		fail(exitCode(err, exitInvalidDPP), "%v", err)
+	}
+	curve, _ := nm2qr.ValidateDPPPublicKey(u.PublicKey)
+	j := jsonDPP{
+		Info:      u.Info,
+		Version:   u.Version,
+		Host:      u.Host,
+		PublicKey: base64.StdEncoding.EncodeToString(u.PublicKey),
+		Curve:     curve,
+		Uri:       u.String(),
+	}
+	for _, c := range u.Channels {
+		j.Channels = append(j.Channels, c.String())
+	}
+	if len(u.Mac) > 0 {
+		j.Mac = u.Mac.String()
+	}
+	if jsonOutput {
+		if err := writeJson(os.Stdout, j); err != nil {
+			fail(exitOutput, "%v", err)
+		}
+		return
+	}
+	fmt.Printf("channels:\t%s\n", strings.Join(j.Channels, ", "))
+	fmt.Printf("mac:\t%s\n", j.Mac)
+	fmt.Printf("info:\t%s\n", j.Info)
+	fmt.Printf("version:\t%d\n", j.Version)
+	fmt.Printf("host:\t%s\n", j.Host)
+	fmt.Printf("curve:\t%s\n", j.Curve)
+	fmt.Printf("public key:\t%s\n", j.PublicKey)
+}
+
+// keygenDPP creates a configurator bootstrapping key, stores the private
+// key in keyfile and outputs the DPP URI as QR code.
+func keygenDPP(keyfile, info, channels, format, outputname string) {
+	switch format {
+	case "png", "string", "plain":
+	default:
+		fail(exitUsage, "the DPP URI is output as QR code, format %s is not possible (use png, string or plain)", format)
+	}
+	u, private, err := nm2qr.NewDPPBootstrap()
+	if nil != err {
+		fail(exitFailure, "generating DPP key: %v", err)
+	}
+	u.Info = info
+	if channels != "" {
+		// reuse the validation of the parser
+		parsed, err := nm2qr.ParseDPPURI(fmt.Sprintf("DPP:C:%s;K:%s;;", channels, base64.StdEncoding.EncodeToString(u.PublicKey)))
+		if nil != err {
+			fail(exitUsage, "%v", err)
+		}
+		u.Channels = parsed.Channels
+	}
+	if _, err := nm2qr.ParseDPPURI(u.String()); nil != err {
+		fail(exitUsage, "%v", err)
+	}
+
+	f, err := os.OpenFile(keyfile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if nil != err {
+		fail(exitOutput, "%v", err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "EC PRIVATE KEY", Bytes: private}); nil != err {
+		fail(exitOutput, "writing %s: %v", keyfile, err)
+	}
+	if err := f.Close(); nil != err {
+		fail(exitOutput, "writing %s: %v", keyfile, err)
+	}
+	fmt.Fprintf(os.Stderr, "private key stored in %s\n", keyfile)
+	writeCode(u.String(), format, outputname)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+	ux "github.com/pseyfert/go-networkmanager-qrcode-generator/ux"
+)
+
+// dump writes a fixture of the given connections (all if ids is empty) to
+// stdout, for replay with -backend replay:FILE.
+func dump(ctx context.Context, backend ux.Backend, ids []int, withSecret bool) {
+	b, ok := backend.(ux.DBusBackend)
+	if !ok {
+		fail(exitUsage, "-dump requires the networkmanager backend")
+	}
+	if len(ids) == 0 {
+		var err error
+		ids, err = b.ConnectionIDs(ctx)
+		if err != nil {
+			fail(exitCode(err, exitListing), "%v", err)
+		}
+	}
+	var fixture nm2qr.Fixture
+	for _, id := range ids {
+		c, err := nm2qr.RecordConnection(ctx, id, b.Conn, withSecret)
+		if err != nil {
+			fail(exitCode(err, exitFailure), "recording connection %d: %v", id, err)
+		}
+		fixture.Connections = append(fixture.Connections, c)
+	}
+	if !withSecret {
+		fmt.Fprintf(os.Stderr, "secrets are redacted, use -secret to include them\n")
+	}
+	if err := writeJson(os.Stdout, fixture); err != nil {
+		fail(exitOutput, "%v", err)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+	ux "github.com/pseyfert/go-networkmanager-qrcode-generator/ux"
+)
+
+// Exit codes of the command. These are part of the command line interface,
+// wrapper scripts rely on them. Do not renumber, only append.
+//
+//	 0  success
+//	 1  unclassified failure in network setting retrieval
+//	 2  QR code generation failed
+//	 3  writing the output failed
+//	 4  connection not found
+//	 5  connection name matches several connections
+//	 6  connection type not supported
+//	 7  no connection specified
+//	 8  invalid output format requested
+//	 9  could not connect to the system dbus
+//	10  listing connections failed
+//	11  permission denied by NetworkManager
+//	12  no secret available for the connection
+//	13  connection has no 802-11-wireless-security block
+//	14  the entered key is not valid
+//	15  NetworkManager did not answer in time
+//	16  the backend input file could not be read
+//	17  the DPP URI is invalid
+const (
+	exitOK               = 0
+	exitFailure          = 1
+	exitQRGeneration     = 2
+	exitOutput           = 3
+	exitNotFound         = 4
+	exitAmbiguous        = 5
+	exitUnsupportedType  = 6
+	exitUsage            = 7
+	exitBadFormat        = 8
+	exitDbus             = 9
+	exitListing          = 10
+	exitPermissionDenied = 11
+	exitNoSecret         = 12
+	exitNoSecurityBlock  = 13
+	exitInvalidKey       = 14
+	exitTimeout          = 15
+	exitInput            = 16
+	exitInvalidDPP       = 17
+)
+
+// exitCode maps errors from the nm2qr and ux packages to exit codes.
+// Errors which are not recognised result in fallback.
+func exitCode(err error, fallback int) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, ux.ErrAmbiguousMatch):
+		return exitAmbiguous
+	case errors.Is(err, nm2qr.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, nm2qr.ErrUnsupportedType):
+		return exitUnsupportedType
+	case errors.Is(err, nm2qr.ErrPermissionDenied):
+		return exitPermissionDenied
+	case errors.Is(err, nm2qr.ErrNoSecret):
+		return exitNoSecret
+	case errors.Is(err, nm2qr.ErrNoSecurityBlock):
+		return exitNoSecurityBlock
+	case errors.Is(err, nm2qr.ErrInvalidKey):
+		return exitInvalidKey
+	case errors.Is(err, nm2qr.ErrInvalidDPPURI):
+		return exitInvalidDPP
+	}
+	return fallback
+}
+
+// fail reports an error on stderr and terminates with the given exit code.
+func fail(code int, format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: "+format+"\n", a...)
+	os.Exit(code)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+	ux "github.com/pseyfert/go-networkmanager-qrcode-generator/ux"
+)
+
+// jsonConnection is the machine readable representation of a connection.
+// The field names are part of the command line interface and must not change.
+type jsonConnection struct {
+	Id                string `json:"id"`
+	Uuid              string `json:"uuid"`
+	Type              string `json:"type,omitempty"`
+	Ssid              string `json:"ssid"`
+	SsidHex           string `json:"ssid_hex"`
+	Security          string `json:"security"`
+	Hidden            bool   `json:"hidden"`
+	Psk               bool   `json:"psk"`
+	DbusPath          string `json:"dbus_path"`
+	Key               string `json:"key,omitempty"`
+	Payload           string `json:"payload,omitempty"`
+	SecretSource      string `json:"secret_source,omitempty"`
+	SecretUnavailable bool   `json:"secret_unavailable,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+func newJsonConnection(ns nm2qr.NetworkSetting, withSecret bool) jsonConnection {
+	retval := jsonConnection{
+		Id:                ns.Id,
+		Uuid:              ns.Uuid,
+		Type:              ns.TypeName(),
+		Ssid:              string(ns.Ssid),
+		SsidHex:           hex.EncodeToString(ns.Ssid),
+		Security:          ns.Sec,
+		Hidden:            ns.IsHidden,
+		Psk:               ns.IsPsk,
+		DbusPath:          string(ns.DbusPath()),
+		SecretUnavailable: ns.PermissionDenied,
+	}
+	if withSecret {
+		retval.Key = ns.Key
+		retval.SecretSource = string(ns.KeySource)
+	}
+	// a WIFI: code cannot describe 802.1x connections
+	if !ns.IsEap() {
+		if withSecret && ns.Key != "" {
+			retval.Payload = nm2qr.Payload(ns)
+		} else {
+			retval.Payload = redactedPayload(ns)
+		}
+	}
+	return retval
+}
+
+// redactedPayload is the payload without the secret: the WIFI: code
+// without P: field, the WireGuard configuration without private and
+// preshared keys.
+func redactedPayload(ns nm2qr.NetworkSetting) string {
+	ns.Key = ""
+	if ns.IsWireGuard() {
+		peers := append([]nm2qr.WireGuardPeer{}, ns.WireGuard.Peers...)
+		for i := range peers {
+			peers[i].PresharedKey = ""
+		}
+		ns.WireGuard.Peers = peers
+		return strings.Replace(nm2qr.Payload(ns), "PrivateKey = \n", "", 1)
+	}
+	return strings.Replace(nm2qr.Payload(ns), `P:"";`, "", 1)
+}
+
+// newJsonOther describes a connection of another type than Wi-Fi, or a
+// network of a configuration file which is not supported.
+func newJsonOther(ns nm2qr.NetworkSetting) jsonConnection {
+	retval := jsonConnection{
+		Id:       ns.Id,
+		Uuid:     ns.Uuid,
+		Type:     ns.TypeName(),
+		DbusPath: string(ns.DbusPath()),
+	}
+	if ns.Unsupported != "" {
+		retval.Error = ns.CheckType().Error()
+	}
+	return retval
+}
+
+// newJsonSkipped describes a connection which could not be read, only the
+// fields which are known are filled.
+func newJsonSkipped(f ux.ConnectionError) jsonConnection {
+	return jsonConnection{
+		Id:       f.Id,
+		DbusPath: string(f.Path),
+		Error:    f.Err.Error(),
+	}
+}
+
+func writeJson(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+func settingOf(t *testing.T, c *nmtest.Connection) nm2qr.NetworkSetting {
+	t.Helper()
+	ns, err := nm2qr.NewNetworkSetting(c.Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.AddNetworkSecrets(c.Secrets); err != nil {
+		t.Fatal(err)
+	}
+	return ns
+}
+
+func TestJsonConnectionPayload(t *testing.T) {
+	ns := settingOf(t, nmtest.WifiPsk("home", "uuid-7", "HomeNet", "homesecret"))
+	with := newJsonConnection(ns, true)
+	if with.Key != "homesecret" || with.Payload != `WIFI:T:WPA;P:"homesecret";S:"HomeNet";;` {
+		t.Errorf("unexpected connection with secret %+v", with)
+	}
+	without := newJsonConnection(ns, false)
+	if without.Key != "" || without.SecretSource != "" || without.Payload != `WIFI:T:WPA;S:"HomeNet";;` {
+		t.Errorf("unexpected connection without secret %+v", without)
+	}
+
+	vpn := settingOf(t, nmtest.WireGuard("vpn", "uuid-3", "cHJpdmF0ZQ==", "cHVibGlj", "cHJlc2hhcmVk"))
+	want := `[Interface]
+Address = 10.0.0.2/32
+DNS = 10.0.0.1
+
+[Peer]
+PublicKey = cHVibGlj
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = vpn.example.com:51820
+PersistentKeepalive = 25
+`
+	if got := newJsonConnection(vpn, false).Payload; got != want {
+		t.Errorf("unexpected payload without secret\n%s", got)
+	}
+	if got := newJsonConnection(vpn, true).Payload; got != nm2qr.Payload(vpn) {
+		t.Errorf("unexpected payload with secret\n%s", got)
+	}
+}
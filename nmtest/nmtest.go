@@ -0,0 +1,397 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package nmtest provides a fake NetworkManager settings service on a
+// private dbus, so that code talking to NetworkManager can be exercised in
+// go test without a running NetworkManager.
+//
+// A private bus requires the dbus-daemon executable, Start returns
+// ErrNoDaemon if it cannot be found and tests should be skipped then.
+package nmtest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// ErrNoDaemon is returned by Start when no dbus-daemon is available.
+var ErrNoDaemon = errors.New("dbus-daemon not available")
+
+const (
+	busName             = "org.freedesktop.NetworkManager"
+	settingsPath        = "/org/freedesktop/NetworkManager/Settings"
+	settingsIface       = "org.freedesktop.NetworkManager.Settings"
+	connectionIface     = "org.freedesktop.NetworkManager.Settings.Connection"
+	introspectableIface = "org.freedesktop.DBus.Introspectable"
+	introspectHeader    = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+`
+	permissionDenied  = "org.freedesktop.NetworkManager.Settings.PermissionDenied"
+	invalidConnection = "org.freedesktop.NetworkManager.Settings.InvalidConnection"
+	busConfigTemplate = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+)
+
+// Connection is a fake settings connection.
+type Connection struct {
+	// Settings is returned by GetSettings.
+	Settings map[string]map[string]dbus.Variant
+	// Secrets is returned by GetSecrets, restricted to the requested
+	// setting.
+	Secrets map[string]map[string]dbus.Variant
+	// SettingsError and SecretsError, when set, are returned instead of
+	// the settings and secrets.
+	SettingsError *dbus.Error
+	SecretsError  *dbus.Error
+	// Delay is waited before every reply.
+	Delay time.Duration
+}
+
+// PermissionDenied is the error NetworkManager returns to clients which are
+// not authorized to read secrets.
+func PermissionDenied() *dbus.Error {
+	return dbus.NewError(permissionDenied, []interface{}{"Insufficient privileges"})
+}
+
+// InvalidConnection is the error NetworkManager returns for broken
+// connections.
+func InvalidConnection(msg string) *dbus.Error {
+	return dbus.NewError(invalidConnection, []interface{}{msg})
+}
+
+// WifiPsk builds a WPA-PSK Wi-Fi connection as NetworkManager reports it.
+func WifiPsk(id, uuid, ssid, psk string) *Connection {
+	return &Connection{
+		Settings: map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":   dbus.MakeVariant(id),
+				"uuid": dbus.MakeVariant(uuid),
+				"type": dbus.MakeVariant("802-11-wireless"),
+			},
+			"802-11-wireless": {
+				"ssid":     dbus.MakeVariant([]byte(ssid)),
+				"mode":     dbus.MakeVariant("infrastructure"),
+				"security": dbus.MakeVariant("802-11-wireless-security"),
+			},
+			"802-11-wireless-security": {
+				"key-mgmt": dbus.MakeVariant("wpa-psk"),
+				"auth-alg": dbus.MakeVariant("open"),
+			},
+		},
+		Secrets: map[string]map[string]dbus.Variant{
+			"802-11-wireless-security": {
+				"psk": dbus.MakeVariant(psk),
+			},
+		},
+	}
+}
+
+// WifiOpen builds an open Wi-Fi connection as NetworkManager reports it,
+// without 802-11-wireless-security block.
+func WifiOpen(id, uuid, ssid string) *Connection {
+	return &Connection{
+		Settings: map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":   dbus.MakeVariant(id),
+				"uuid": dbus.MakeVariant(uuid),
+				"type": dbus.MakeVariant("802-11-wireless"),
+			},
+			"802-11-wireless": {
+				"ssid": dbus.MakeVariant([]byte(ssid)),
+				"mode": dbus.MakeVariant("infrastructure"),
+			},
+		},
+	}
+}
+
+// WifiWep builds a static WEP Wi-Fi connection as NetworkManager reports
+// it, the key is stored as wep-key<idx>.
+func WifiWep(id, uuid, ssid, key string, idx uint32) *Connection {
+	return &Connection{
+		Settings: map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":   dbus.MakeVariant(id),
+				"uuid": dbus.MakeVariant(uuid),
+				"type": dbus.MakeVariant("802-11-wireless"),
+			},
+			"802-11-wireless": {
+				"ssid":     dbus.MakeVariant([]byte(ssid)),
+				"mode":     dbus.MakeVariant("infrastructure"),
+				"security": dbus.MakeVariant("802-11-wireless-security"),
+			},
+			"802-11-wireless-security": {
+				"key-mgmt":      dbus.MakeVariant("none"),
+				"auth-alg":      dbus.MakeVariant("open"),
+				"wep-key-type":  dbus.MakeVariant(uint32(1)),
+				"wep-tx-keyidx": dbus.MakeVariant(idx),
+			},
+		},
+		Secrets: map[string]map[string]dbus.Variant{
+			"802-11-wireless-security": {
+				fmt.Sprintf("wep-key%d", idx): dbus.MakeVariant(key),
+			},
+		},
+	}
+}
+
+// WireGuard builds a WireGuard connection with one peer as NetworkManager
+// reports it, the peer's preshared key is only part of the secrets.
+func WireGuard(id, uuid, privateKey, peerKey, presharedKey string) *Connection {
+	return &Connection{
+		Settings: map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":             dbus.MakeVariant(id),
+				"uuid":           dbus.MakeVariant(uuid),
+				"type":           dbus.MakeVariant("wireguard"),
+				"interface-name": dbus.MakeVariant("wg0"),
+			},
+			"wireguard": {
+				"peers": dbus.MakeVariant([]map[string]dbus.Variant{{
+					"public-key":           dbus.MakeVariant(peerKey),
+					"endpoint":             dbus.MakeVariant("vpn.example.com:51820"),
+					"allowed-ips":          dbus.MakeVariant([]string{"0.0.0.0/0", "::/0"}),
+					"persistent-keepalive": dbus.MakeVariant(uint32(25)),
+				}}),
+			},
+			"ipv4": {
+				"method": dbus.MakeVariant("manual"),
+				"address-data": dbus.MakeVariant([]map[string]dbus.Variant{{
+					"address": dbus.MakeVariant("10.0.0.2"),
+					"prefix":  dbus.MakeVariant(uint32(32)),
+				}}),
+				// 10.0.0.1 as network byte order integer
+				"dns": dbus.MakeVariant([]uint32{0x0100000a}),
+			},
+			"ipv6": {
+				"method": dbus.MakeVariant("ignore"),
+			},
+		},
+		Secrets: map[string]map[string]dbus.Variant{
+			"wireguard": {
+				"private-key": dbus.MakeVariant(privateKey),
+				"peers": dbus.MakeVariant([]map[string]dbus.Variant{{
+					"public-key":    dbus.MakeVariant(peerKey),
+					"preshared-key": dbus.MakeVariant(presharedKey),
+				}}),
+			},
+		},
+	}
+}
+
+// Ethernet builds a wired connection as NetworkManager reports it.
+func Ethernet(id, uuid string) *Connection {
+	return &Connection{
+		Settings: map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":   dbus.MakeVariant(id),
+				"uuid": dbus.MakeVariant(uuid),
+				"type": dbus.MakeVariant("802-3-ethernet"),
+			},
+			"802-3-ethernet": {},
+		},
+	}
+}
+
+type connectionObject struct {
+	*Connection
+}
+
+func (c connectionObject) GetSettings() (map[string]map[string]dbus.Variant, *dbus.Error) {
+	time.Sleep(c.Delay)
+	if c.SettingsError != nil {
+		return nil, c.SettingsError
+	}
+	return c.Settings, nil
+}
+
+func (c connectionObject) GetSecrets(setting string) (map[string]map[string]dbus.Variant, *dbus.Error) {
+	time.Sleep(c.Delay)
+	if c.SecretsError != nil {
+		return nil, c.SecretsError
+	}
+	retval := make(map[string]map[string]dbus.Variant)
+	if block, found := c.Secrets[setting]; found {
+		retval[setting] = block
+	}
+	return retval, nil
+}
+
+// settingsObject is the fake settings service, it lists the connections
+// in the order of their settings ids.
+type settingsObject struct {
+	ids []int
+}
+
+func (s settingsObject) ListConnections() ([]dbus.ObjectPath, *dbus.Error) {
+	paths := make([]dbus.ObjectPath, len(s.ids))
+	for i, id := range s.ids {
+		paths[i] = connectionPath(id)
+	}
+	return paths, nil
+}
+
+// Introspect lists the connections as child nodes, once an object is
+// exported on the settings path godbus no longer does this by itself.
+func (s settingsObject) Introspect() (string, *dbus.Error) {
+	var b strings.Builder
+	b.WriteString(introspectHeader)
+	fmt.Fprintf(&b, "<node>\n  <interface name=%q>\n    <method name=\"ListConnections\">\n", settingsIface)
+	b.WriteString("      <arg name=\"connections\" type=\"ao\" direction=\"out\"/>\n    </method>\n  </interface>\n")
+	for _, id := range s.ids {
+		fmt.Fprintf(&b, "  <node name=\"%d\"/>\n", id)
+	}
+	b.WriteString("</node>\n")
+	return b.String(), nil
+}
+
+func connectionPath(id int) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("%s/%d", settingsPath, id))
+}
+
+// Server is a private bus on which a fake NetworkManager is running.
+type Server struct {
+	// Address is the dbus address of the private bus.
+	Address string
+	dir     string
+	daemon  *exec.Cmd
+	service *dbus.Conn
+}
+
+// Start launches a private bus and a fake NetworkManager serving the given
+// connections under their settings ids.
+func Start(connections map[int]*Connection) (*Server, error) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		return nil, ErrNoDaemon
+	}
+	dir, err := os.MkdirTemp("", "nmtest")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{dir: dir}
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(fmt.Sprintf(busConfigTemplate, filepath.Join(dir, "bus"))), 0600)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.daemon = exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := s.daemon.StdoutPipe()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.daemon.Start(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%w: %v", ErrNoDaemon, err)
+	}
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("reading bus address: %v", err)
+	}
+	s.Address = strings.TrimSpace(address)
+
+	s.service, err = s.Dial()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.export(connections); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) export(connections map[int]*Connection) error {
+	// the connections are found by introspecting the settings path or by
+	// ListConnections, the settings object answers both.
+	var settings settingsObject
+	for id, c := range connections {
+		if err := s.service.Export(connectionObject{c}, connectionPath(id), connectionIface); err != nil {
+			return err
+		}
+		settings.ids = append(settings.ids, id)
+	}
+	sort.Ints(settings.ids)
+	if err := s.service.Export(settings, settingsPath, settingsIface); err != nil {
+		return err
+	}
+	if err := s.service.Export(settings, settingsPath, introspectableIface); err != nil {
+		return err
+	}
+	reply, err := s.service.RequestName(busName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("could not own %s on private bus", busName)
+	}
+	return nil
+}
+
+// Dial opens a new client connection to the private bus.
+func (s *Server) Dial() (*dbus.Conn, error) {
+	conn, err := dbus.Dial(s.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Close stops the fake NetworkManager and the private bus.
+func (s *Server) Close() error {
+	if s.service != nil {
+		s.service.Close()
+	}
+	if s.daemon != nil && s.daemon.Process != nil {
+		s.daemon.Process.Kill()
+		s.daemon.Wait()
+	}
+	return os.RemoveAll(s.dir)
+}
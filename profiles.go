@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+// profileFormat is an output format which writes a configuration file for
+// another device or system instead of a QR code.
+type profileFormat struct {
+	extension string // of the default output file
+	render    func(nm2qr.NetworkSetting) ([]byte, error)
+	// filename, if set, names the default output file after the
+	// connection instead
+	filename func(nm2qr.NetworkSetting) string
+}
+
+var profileFormats = map[string]profileFormat{
+	"mobileconfig":  {".mobileconfig", nm2qr.Mobileconfig, nil},
+	"wlanprofile":   {".xml", nm2qr.WLANProfile, nil},
+	"onc":           {".onc", nm2qr.ONC, nil},
+	"keyfile":       {".nmconnection", nm2qr.Keyfile, nil},
+	"wpasupplicant": {".conf", nm2qr.WpaSupplicant, nil},
+	"iwd":           {"", nm2qr.Iwd, nm2qr.IwdFilename},
+	"netplan":       {".yaml", nm2qr.Netplan, nil},
+}
+
+// writeProfile writes a configuration file, "-" writes to stdout. Files
+// are only readable by the user since they contain the secret.
+func writeProfile(data []byte, outputname string) error {
+	if outputname == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeSecretFile(outputname, data)
+}
+
+// writeSecretFile writes a file only readable by the user, also when it
+// replaces an existing file with other permissions.
+func writeSecretFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if nil != err {
+		return err
+	}
+	if err := f.Chmod(0600); nil != err {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFiles writes configuration files into a directory, only readable by
+// the user, and reports them on stderr.
+func writeFiles(dir string, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := writeSecretFile(path, files[name]); nil != err {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ShellQuote quotes a word for a POSIX shell, words which need no quotes
+// are returned unchanged.
+func ShellQuote(word string) string {
+	if word == "" {
+		return "''"
+	}
+	for _, c := range word {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("_@%+=:,./-", c)) {
+			return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+		}
+	}
+	return word
+}
+
+func shellCommand(words ...string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = ShellQuote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// commandSsid returns the SSID for a command line, which cannot carry NUL
+// bytes.
+func commandSsid(ns NetworkSetting) (string, error) {
+	if strings.ContainsRune(string(ns.Ssid), 0) {
+		return "", fmt.Errorf("%w: the SSID of %s contains a NUL byte and cannot be given on a command line", ErrUnsupportedType, ns.Id)
+	}
+	return string(ns.Ssid), nil
+}
+
+// NmcliCommand returns an nmcli command which adds the Wi-Fi connection on
+// another machine. The CA certificate of an 802.1x connection can only be
+// given as file.
+func NmcliCommand(ns NetworkSetting) (string, error) {
+	if err := ns.CheckType(); nil != err {
+		return "", err
+	}
+	if ns.IsWireGuard() {
+		return "", &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	ssid, err := commandSsid(ns)
+	if nil != err {
+		return "", err
+	}
+	id := ns.Id
+	if id == "" {
+		id = ssid
+	}
+	args := []string{"nmcli", "connection", "add", "type", "wifi", "con-name", id, "ssid", ssid}
+	if ns.IsHidden {
+		args = append(args, "wifi.hidden", "yes")
+	}
+	if !ns.Connection.Autoconnect {
+		args = append(args, "connection.autoconnect", "no")
+	}
+	s := ns.Security
+	if s.KeyMgmt != "" {
+		args = append(args, "wifi-sec.key-mgmt", s.KeyMgmt)
+	}
+	switch {
+	case s.KeyMgmt == "none":
+		if ns.Key != "" {
+			args = append(args, fmt.Sprintf("wifi-sec.wep-key%d", s.WepTxKeyidx), ns.Key)
+		}
+		if s.WepTxKeyidx != 0 {
+			args = append(args, "wifi-sec.wep-tx-keyidx", fmt.Sprint(s.WepTxKeyidx))
+		}
+		if s.WepKeyType != 0 {
+			args = append(args, "wifi-sec.wep-key-type", fmt.Sprint(s.WepKeyType))
+		}
+	case ns.IsPsk:
+		if ns.Key != "" {
+			args = append(args, "wifi-sec.psk", ns.Key)
+		}
+	case ns.IsEap():
+		e := ns.Ieee8021x
+		args = append(args, "802-1x.eap", strings.Join(e.Eap, ","))
+		for _, field := range []struct{ key, value string }{
+			{"802-1x.identity", e.Identity},
+			{"802-1x.anonymous-identity", e.AnonymousIdentity},
+			{"802-1x.phase2-auth", e.Phase2Auth},
+			{"802-1x.phase2-autheap", e.Phase2Autheap},
+			{"802-1x.domain-suffix-match", e.DomainSuffixMatch},
+			{"802-1x.domain-match", e.DomainMatch},
+			{"802-1x.password", ns.Key},
+		} {
+			if field.value != "" {
+				args = append(args, field.key, field.value)
+			}
+		}
+		if path, isPath := e.CaCertPath(); isPath {
+			args = append(args, "802-1x.ca-cert", path)
+		} else if len(e.CaCert) > 0 {
+			return "", fmt.Errorf("%w: the CA certificate of %s is stored by NetworkManager and cannot be given to nmcli, export a keyfile instead", ErrUnsupportedType, ns.Id)
+		}
+	}
+	return shellCommand(args...), nil
+}
+
+// IwctlCommand returns an iwctl command which connects the station
+// (interface-name, wlan0 if the connection is not bound to one) to the
+// network. iwctl can only connect to open and personal networks.
+func IwctlCommand(ns NetworkSetting) (string, error) {
+	if err := ns.CheckType(); nil != err {
+		return "", err
+	}
+	if ns.IsWireGuard() {
+		return "", &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	extension, err := iwdExtension(ns)
+	if nil != err {
+		return "", err
+	}
+	if extension == ".8021x" {
+		return "", fmt.Errorf("%w: iwctl cannot connect to 802.1x network %s, export an iwd profile instead", ErrUnsupportedType, ns.Id)
+	}
+	ssid, err := commandSsid(ns)
+	if nil != err {
+		return "", err
+	}
+	station := ns.Connection.InterfaceName
+	if station == "" {
+		station = "wlan0"
+	}
+	args := []string{"iwctl"}
+	if ns.IsPsk && ns.Key != "" {
+		args = append(args, "--passphrase", ns.Key)
+	}
+	connect := "connect"
+	if ns.IsHidden {
+		connect = "connect-hidden"
+	}
+	return shellCommand(append(args, "station", station, connect, ssid)...), nil
+}
+
+// AdbCommand returns an adb command which connects an Android device
+// (Android 11 or newer) to the network. The command is quoted twice since
+// adb shell passes it to the shell of the device.
+func AdbCommand(ns NetworkSetting) (string, error) {
+	if err := ns.CheckType(); nil != err {
+		return "", err
+	}
+	if ns.IsWireGuard() {
+		return "", &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	ssid, err := commandSsid(ns)
+	if nil != err {
+		return "", err
+	}
+	var security string
+	switch s := ns.Security.KeyMgmt; {
+	case s == "":
+		security = "open"
+	case s == "owe":
+		security = "owe"
+	case s == "none":
+		security = "wep"
+	case s == "sae":
+		security = "wpa3"
+	case s == "wpa-psk":
+		security = "wpa2"
+	default:
+		return "", fmt.Errorf("%w: adb cannot connect to %s network %s", ErrUnsupportedType, s, ns.Id)
+	}
+	args := []string{"cmd", "wifi", "connect-network", ssid, security}
+	if security == "wep" || security == "wpa2" || security == "wpa3" {
+		if ns.Key == "" {
+			return "", fmt.Errorf("%w: adb needs the secret of %s", ErrNoSecret, ns.Id)
+		}
+		args = append(args, ns.Key)
+	}
+	if ns.IsHidden {
+		args = append(args, "-h")
+	}
+	return shellCommand("adb", "shell", shellCommand(args...)), nil
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for word, want := range map[string]string{
+		"":          "''",
+		"wlan0":     "wlan0",
+		"My Net":    "'My Net'",
+		"it's":      `'it'\''s'`,
+		"$(reboot)": "'$(reboot)'",
+	} {
+		if got := ShellQuote(word); got != want {
+			t.Errorf("%q quoted as %s, expected %s", word, got, want)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Ssid = []byte("Bob's Net")
+	ns.Key = "pa$$ word"
+	for name, tc := range map[string]struct {
+		command func(NetworkSetting) (string, error)
+		want    string
+	}{
+		"nmcli": {NmcliCommand, `nmcli connection add type wifi con-name 'say "hello"' ssid 'Bob'\''s Net' wifi.hidden yes wifi-sec.key-mgmt wpa-psk wifi-sec.psk 'pa$$ word'`},
+		"iwctl": {IwctlCommand, `iwctl --passphrase 'pa$$ word' station wlan0 connect-hidden 'Bob'\''s Net'`},
+		"adb":   {AdbCommand, `adb shell 'cmd wifi connect-network '\''Bob'\''\'\'''\''s Net'\'' wpa2 '\''pa$$ word'\'' -h'`},
+	} {
+		got, err := tc.command(ns)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if got != tc.want {
+			t.Errorf("%s: unexpected command\n%s\nexpected\n%s", name, got, tc.want)
+		}
+	}
+
+	ns.Security.KeyMgmt = "wpa-eap"
+	ns.IsPsk = false
+	ns.Ieee8021x = Ieee8021xSettings{Eap: []string{"peap"}, Identity: "bob", Phase2Auth: "mschapv2"}
+	got, err := NmcliCommand(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `nmcli connection add type wifi con-name 'say "hello"' ssid 'Bob'\''s Net' wifi.hidden yes wifi-sec.key-mgmt wpa-eap 802-1x.eap peap 802-1x.identity bob 802-1x.phase2-auth mschapv2 802-1x.password 'pa$$ word'`; got != want {
+		t.Errorf("unexpected 802.1x command\n%s", got)
+	}
+	for name, command := range map[string]func(NetworkSetting) (string, error){"iwctl": IwctlCommand, "adb": AdbCommand} {
+		if _, err := command(ns); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("%s: expected 802.1x to be unsupported, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// DecodeError reports a settings value which is missing or does not have
+// the type NetworkManager documents for it.
+type DecodeError struct {
+	Setting string // settings block, e.g. "802-11-wireless"
+	Key     string
+	Want    string // expected dbus signature
+	Got     string // received dbus signature, empty if the key is missing
+}
+
+func (e *DecodeError) Error() string {
+	if e.Got == "" {
+		return fmt.Sprintf("%s.%s is missing", e.Setting, e.Key)
+	}
+	return fmt.Sprintf("%s.%s has type %s, expected %s", e.Setting, e.Key, e.Got, e.Want)
+}
+
+// settingsMap checks that a GetSettings or GetSecrets reply body is a
+// settings map.
+func settingsMap(callbody interface{}) (map[string]map[string]dbus.Variant, error) {
+	settings, ok := callbody.(map[string]map[string]dbus.Variant)
+	if !ok {
+		return nil, fmt.Errorf("settings have type %T, expected a{sa{sv}}", callbody)
+	}
+	return settings, nil
+}
+
+// decodeBlock fills the struct pointed to by v from a settings block. The
+// struct fields name their key with a `dbus:"key"` tag, `dbus:"key,required"`
+// makes a missing key an error. Keys without a field are ignored, fields
+// without a key keep their value. found is false if the block is missing.
+func decodeBlock(settings map[string]map[string]dbus.Variant, name string, v interface{}) (found bool, err error) {
+	block, found := settings[name]
+	if !found {
+		return false, nil
+	}
+	return true, decodeFields(name, block, v)
+}
+
+// decodeFields is decodeBlock for a single a{sv} dictionary, e.g. a
+// WireGuard peer. name is only used in errors.
+func decodeFields(name string, block map[string]dbus.Variant, v interface{}) error {
+	target := reflect.ValueOf(v).Elem()
+	for i := 0; i < target.NumField(); i++ {
+		tag, ok := target.Type().Field(i).Tag.Lookup("dbus")
+		if !ok {
+			continue
+		}
+		key, options, _ := strings.Cut(tag, ",")
+		field := target.Field(i)
+		variant, present := block[key]
+		if !present {
+			if options == "required" {
+				return &DecodeError{Setting: name, Key: key, Want: dbus.SignatureOfType(field.Type()).String()}
+			}
+			continue
+		}
+		if !assign(field, variant) {
+			return &DecodeError{
+				Setting: name,
+				Key:     key,
+				Want:    dbus.SignatureOfType(field.Type()).String(),
+				Got:     variant.Signature().String(),
+			}
+		}
+	}
+	return nil
+}
+
+// assign stores the value of a variant in field if it has the field's
+// dbus type.
+func assign(field reflect.Value, variant dbus.Variant) bool {
+	value := reflect.ValueOf(variant.Value())
+	if !value.IsValid() {
+		return false
+	}
+	if value.Type() == field.Type() {
+		field.Set(value)
+		return true
+	}
+	// named types like SecretFlags
+	if value.Kind() == field.Kind() && value.Type().ConvertibleTo(field.Type()) {
+		field.Set(value.Convert(field.Type()))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+func wifiSettings() map[string]map[string]dbus.Variant {
+	return map[string]map[string]dbus.Variant{
+		"connection": {
+			"id":   dbus.MakeVariant(`say "hello"`),
+			"uuid": dbus.MakeVariant("uuid-1"),
+		},
+		"802-11-wireless": {
+			"ssid":   dbus.MakeVariant([]byte("Net")),
+			"hidden": dbus.MakeVariant(true),
+		},
+		"802-11-wireless-security": {
+			"key-mgmt":  dbus.MakeVariant("wpa-psk"),
+			"psk-flags": dbus.MakeVariant(uint32(SecretFlagAgentOwned)),
+			"proto":     dbus.MakeVariant([]string{"rsn"}),
+			"pmf":       dbus.MakeVariant(int32(PmfRequired)),
+		},
+	}
+}
+
+func TestNewNetworkSetting(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Id != `say "hello"` || string(ns.Ssid) != "Net" || ns.Sec != "WPA" || !ns.IsPsk || ns.PskFlags != SecretFlagAgentOwned {
+		t.Errorf("unexpected settings %+v", ns)
+	}
+	if !ns.IsHidden || ns.Wireless.Mode != "infrastructure" || !ns.Connection.Autoconnect {
+		t.Errorf("unexpected wireless settings %+v", ns.Wireless)
+	}
+	if len(ns.Security.Proto) != 1 || ns.Security.Proto[0] != "rsn" || ns.Security.Pmf != PmfRequired {
+		t.Errorf("unexpected security settings %+v", ns.Security)
+	}
+}
+
+func TestNewNetworkSettingMalformed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		setting, key string
+		value        interface{}
+		want         DecodeError
+	}{
+		"ssid as string": {"802-11-wireless", "ssid", "Net", DecodeError{Setting: "802-11-wireless", Key: "ssid", Want: "ay", Got: "s"}},
+		"id as int":      {"connection", "id", int32(1), DecodeError{Setting: "connection", Key: "id", Want: "s", Got: "i"}},
+		"flags as int":   {"802-11-wireless-security", "psk-flags", int32(1), DecodeError{Setting: "802-11-wireless-security", Key: "psk-flags", Want: "u", Got: "i"}},
+		"missing ssid":   {"802-11-wireless", "ssid", nil, DecodeError{Setting: "802-11-wireless", Key: "ssid", Want: "ay"}},
+	} {
+		settings := wifiSettings()
+		if tc.value == nil {
+			delete(settings[tc.setting], tc.key)
+		} else {
+			settings[tc.setting][tc.key] = dbus.MakeVariant(tc.value)
+		}
+		_, err := NewNetworkSetting(settings)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || *decodeErr != tc.want {
+			t.Errorf("%s: expected %v, got %v", name, &tc.want, err)
+		}
+	}
+
+	if _, err := NewNetworkSetting("not a map"); err == nil {
+		t.Errorf("expected an error for a body which is no settings map")
+	}
+	ns := NetworkSetting{}
+	if err := ns.AddNetworkSecrets(map[string]map[string]dbus.Variant{
+		"802-11-wireless-security": {"psk": dbus.MakeVariant([]byte("secret"))},
+	}); err == nil {
+		t.Errorf("expected an error for a psk which is no string")
+	}
+}
+
+// FuzzNewNetworkSetting feeds arbitrary recorded settings (as written by
+// -dump) to the decoders, which have to return errors instead of panicking.
+func FuzzNewNetworkSetting(f *testing.F) {
+	seed, err := NewRecordedSettings(wifiSettings())
+	if err != nil {
+		f.Fatal(err)
+	}
+	raw, err := json.Marshal(seed)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(raw)
+	f.Add([]byte(`{"802-11-wireless":{"ssid":{"signature":"s","value":"Net"}}}`))
+	f.Add([]byte(`{"connection":{"id":{"signature":"v","value":{"signature":"as","value":["a"]}}}}`))
+	f.Add([]byte(`{"802-11-wireless-security":{"psk":{"signature":"a{sv}","value":{}}}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var rs RecordedSettings
+		if err := json.Unmarshal(data, &rs); err != nil {
+			return
+		}
+		settings, err := rs.Map()
+		if err != nil {
+			return
+		}
+		ns, _ := NewNetworkSetting(settings)
+		ns.AddNetworkSecrets(settings)
+	})
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DPPChannel is a global operating class and channel from the channel list
+// of a DPP URI, e.g. 81/1 for channel 1 in the 2.4GHz band.
+type DPPChannel struct {
+	Class   int
+	Channel int
+}
+
+func (c DPPChannel) String() string {
+	return fmt.Sprintf("%d/%d", c.Class, c.Channel)
+}
+
+// DPPURI is a Wi-Fi Easy Connect (DPP) bootstrapping URI, the payload of
+// QR codes starting with "DPP:".
+//
+//	DPP:C:81/1,115/36;M:5254005828e5;I:SN=4774LH2b4044;V:2;K:MDkw...;;
+type DPPURI struct {
+	Channels  []DPPChannel     // C: channels the device listens on
+	Mac       net.HardwareAddr // M: MAC address
+	Info      string           // I: free form information, e.g. a serial number
+	Version   int              // V: DPP version, 0 if not given
+	Host      string           // H: host name or address for DPP over TCP
+	PublicKey []byte           // K: DER encoded SubjectPublicKeyInfo
+}
+
+// the elliptic curves DPP bootstrapping keys may use
+var dppCurves = map[string]string{
+	"1.2.840.10045.3.1.7":   "prime256v1",
+	"1.3.132.0.34":          "secp384r1",
+	"1.3.132.0.35":          "secp521r1",
+	"1.3.36.3.3.2.8.1.1.7":  "brainpoolP256r1",
+	"1.3.36.3.3.2.8.1.1.11": "brainpoolP384r1",
+	"1.3.36.3.3.2.8.1.1.13": "brainpoolP512r1",
+}
+
+// byte length of a coordinate on the curves in dppCurves
+var dppCurveSizes = map[string]int{
+	"prime256v1":      32,
+	"secp384r1":       48,
+	"secp521r1":       66,
+	"brainpoolP256r1": 32,
+	"brainpoolP384r1": 48,
+	"brainpoolP512r1": 64,
+}
+
+var oidEcPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+type subjectPublicKeyInfo struct {
+	Algorithm struct {
+		Algorithm asn1.ObjectIdentifier
+		Curve     asn1.ObjectIdentifier
+	}
+	PublicKey asn1.BitString
+}
+
+// ValidateDPPPublicKey checks that der is a SubjectPublicKeyInfo of an
+// elliptic curve key on one of the curves DPP allows and returns the curve
+// name.
+func ValidateDPPPublicKey(der []byte) (string, error) {
+	var info subjectPublicKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if nil != err {
+		return "", fmt.Errorf("%w: public key is no SubjectPublicKeyInfo: %v", ErrInvalidDPPURI, err)
+	}
+	if len(rest) != 0 {
+		return "", fmt.Errorf("%w: trailing data after the public key", ErrInvalidDPPURI)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidEcPublicKey) {
+		return "", fmt.Errorf("%w: public key is no elliptic curve key (%v)", ErrInvalidDPPURI, info.Algorithm.Algorithm)
+	}
+	curve, found := dppCurves[info.Algorithm.Curve.String()]
+	if !found {
+		return "", fmt.Errorf("%w: unsupported curve %v", ErrInvalidDPPURI, info.Algorithm.Curve)
+	}
+	point, size := info.PublicKey.Bytes, dppCurveSizes[curve]
+	switch {
+	case info.PublicKey.BitLength != 8*len(point): // reported below
+	case len(point) == 1+2*size && point[0] == 4: // uncompressed
+		return curve, nil
+	case len(point) == 1+size && (point[0] == 2 || point[0] == 3): // compressed
+		return curve, nil
+	}
+	return "", fmt.Errorf("%w: malformed %s point", ErrInvalidDPPURI, curve)
+}
+
+// ParseDPPURI parses and validates a DPP bootstrapping URI as scanned from
+// a device. Unknown tokens are ignored.
+func ParseDPPURI(uri string) (DPPURI, error) {
+	var retval DPPURI
+	if !strings.HasPrefix(uri, "DPP:") || !strings.HasSuffix(uri, ";;") {
+		return retval, fmt.Errorf("%w: must start with \"DPP:\" and end with \";;\"", ErrInvalidDPPURI)
+	}
+	seen := make(map[string]bool)
+	for _, token := range strings.Split(uri[len("DPP:"):len(uri)-len(";;")], ";") {
+		name, value, found := strings.Cut(token, ":")
+		if !found || len(name) != 1 {
+			return retval, fmt.Errorf("%w: malformed token %q", ErrInvalidDPPURI, token)
+		}
+		if seen[name] {
+			return retval, fmt.Errorf("%w: duplicate %s: token", ErrInvalidDPPURI, name)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "C":
+			retval.Channels, err = parseDPPChannels(value)
+		case "M":
+			var mac []byte
+			mac, err = hex.DecodeString(value)
+			if nil != err || len(mac) != 6 {
+				err = fmt.Errorf("%w: MAC address must be 12 hex digits, got %q", ErrInvalidDPPURI, value)
+			}
+			retval.Mac = mac
+		case "I":
+			retval.Info = value
+			if !isPrintableASCII(value) {
+				err = fmt.Errorf("%w: information must be printable ASCII", ErrInvalidDPPURI)
+			}
+		case "V":
+			retval.Version, err = strconv.Atoi(value)
+			if nil != err || retval.Version < 1 {
+				err = fmt.Errorf("%w: invalid version %q", ErrInvalidDPPURI, value)
+			}
+		case "H":
+			retval.Host = value
+		case "K":
+			retval.PublicKey, err = base64.StdEncoding.DecodeString(value)
+			if nil != err {
+				err = fmt.Errorf("%w: public key is not base64: %v", ErrInvalidDPPURI, err)
+			} else {
+				_, err = ValidateDPPPublicKey(retval.PublicKey)
+			}
+		}
+		if nil != err {
+			return retval, err
+		}
+	}
+	if !seen["K"] {
+		return retval, fmt.Errorf("%w: no public key (K:)", ErrInvalidDPPURI)
+	}
+	return retval, nil
+}
+
+func parseDPPChannels(list string) ([]DPPChannel, error) {
+	var retval []DPPChannel
+	for _, entry := range strings.Split(list, ",") {
+		class, channel, found := strings.Cut(entry, "/")
+		c, cerr := strconv.Atoi(class)
+		n, nerr := strconv.Atoi(channel)
+		if !found || nil != cerr || nil != nerr || c < 1 || c > 255 || n < 1 || n > 255 {
+			return nil, fmt.Errorf("%w: invalid channel %q, expected class/channel", ErrInvalidDPPURI, entry)
+		}
+		retval = append(retval, DPPChannel{Class: c, Channel: n})
+	}
+	return retval, nil
+}
+
+// String renders the URI as QR code payload.
+func (u DPPURI) String() string {
+	var b strings.Builder
+	b.WriteString("DPP:")
+	if len(u.Channels) > 0 {
+		channels := make([]string, len(u.Channels))
+		for i, c := range u.Channels {
+			channels[i] = c.String()
+		}
+		fmt.Fprintf(&b, "C:%s;", strings.Join(channels, ","))
+	}
+	if len(u.Mac) > 0 {
+		fmt.Fprintf(&b, "M:%s;", hex.EncodeToString(u.Mac))
+	}
+	if u.Info != "" {
+		fmt.Fprintf(&b, "I:%s;", u.Info)
+	}
+	if u.Version != 0 {
+		fmt.Fprintf(&b, "V:%d;", u.Version)
+	}
+	if u.Host != "" {
+		fmt.Fprintf(&b, "H:%s;", u.Host)
+	}
+	fmt.Fprintf(&b, "K:%s;;", base64.StdEncoding.EncodeToString(u.PublicKey))
+	return b.String()
+}
+
+// NewDPPBootstrap creates a prime256v1 bootstrapping key pair for a
+// configurator. The URI carries the public key, the DER encoded private key
+// is needed to run the DPP authentication (e.g. wpa_supplicant's
+// dpp_bootstrap_gen key=...).
+func NewDPPBootstrap() (DPPURI, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		return DPPURI{}, nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if nil != err {
+		return DPPURI{}, nil, err
+	}
+	private, err := x509.MarshalECPrivateKey(key)
+	if nil != err {
+		return DPPURI{}, nil, err
+	}
+	return DPPURI{Version: 2, PublicKey: public}, private, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"testing"
+)
+
+// example from the Wi-Fi Easy Connect specification
+const exampleDPPURI = "DPP:C:81/1,115/36;M:5254005828e5;I:SN=4774LH2b4044;V:2;K:MDkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDIgADURzxmttZoIRIPWGoQMV00XHWCAQIhXruVWOz0NjlkIA=;;"
+
+func TestParseDPPURI(t *testing.T) {
+	u, err := ParseDPPURI(exampleDPPURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Channels) != 2 || u.Channels[1] != (DPPChannel{115, 36}) {
+		t.Errorf("unexpected channels %v", u.Channels)
+	}
+	if u.Mac.String() != "52:54:00:58:28:e5" || u.Info != "SN=4774LH2b4044" || u.Version != 2 {
+		t.Errorf("unexpected fields %+v", u)
+	}
+	if curve, err := ValidateDPPPublicKey(u.PublicKey); err != nil || curve != "prime256v1" {
+		t.Errorf("unexpected key: %s %v", curve, err)
+	}
+	if s := u.String(); s != exampleDPPURI {
+		t.Errorf("round trip changed the URI to %s", s)
+	}
+}
+
+func TestParseDPPURIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"WIFI:S:net;;",
+		"DPP:C:81/1;;",
+		"DPP:C:81;K:MDkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDIgADURzxmttZoIRIPWGoQMV00XHWCAQIhXruVWOz0NjlkIA=;;",
+		"DPP:M:5254005828;K:MDkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDIgADURzxmttZoIRIPWGoQMV00XHWCAQIhXruVWOz0NjlkIA=;;",
+		"DPP:K:bm90IGEga2V5;;",
+		"DPP:K:MDkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDIgADURzxmttZoIRIPWGoQMV00XHWCAQIhXruVWOz0NjlkIA=;K:MDkw;;",
+	} {
+		if _, err := ParseDPPURI(uri); !errors.Is(err, ErrInvalidDPPURI) {
+			t.Errorf("%s: expected invalid DPP URI, got %v", uri, err)
+		}
+	}
+}
+
+func TestNewDPPBootstrap(t *testing.T) {
+	u, private, err := NewDPPBootstrap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(private) == 0 {
+		t.Errorf("no private key")
+	}
+	u.Channels = []DPPChannel{{81, 6}}
+	if _, err := ParseDPPURI(u.String()); err != nil {
+		t.Errorf("generated URI %s does not parse: %v", u, err)
+	}
+}
+
+func FuzzParseDPPURI(f *testing.F) {
+	f.Add(exampleDPPURI)
+	f.Add("DPP:K:;;")
+	f.Fuzz(func(t *testing.T, uri string) {
+		u, err := ParseDPPURI(uri)
+		if err != nil {
+			return
+		}
+		if _, err := ParseDPPURI(u.String()); err != nil {
+			t.Errorf("%s parsed but its rendering %s does not: %v", uri, u, err)
+		}
+	})
+}
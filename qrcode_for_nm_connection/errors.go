@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// Errors returned (possibly wrapped) by the functions of this package. Use
+// errors.Is to test for them.
+var (
+	ErrNoSecurityBlock  = errors.New("no 802-11-wireless-security block")
+	ErrNoSecret         = errors.New("no secret available")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotFound         = errors.New("connection not found")
+	ErrUnsupportedType  = errors.New("unsupported connection type")
+	ErrInvalidKey       = errors.New("invalid key")
+	ErrInvalidDPPURI    = errors.New("invalid DPP URI")
+)
+
+// UnsupportedTypeError is returned for connections which are not Wi-Fi
+// connections. It matches ErrUnsupportedType with errors.Is.
+type UnsupportedTypeError struct {
+	Id   string
+	Type string // NetworkManager connection type, empty if unknown
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("connection %s has no \"802-11-wireless\" settings, no QR code available", e.Id)
+	}
+	name := TypeName(e.Type)
+	article := "a"
+	if strings.ContainsRune("aeiou", rune(name[0])) {
+		article = "an"
+	}
+	return fmt.Sprintf("connection %s is %s %s connection, no QR code available", e.Id, article, name)
+}
+
+func (e *UnsupportedTypeError) Is(target error) bool {
+	return target == ErrUnsupportedType
+}
+
+// PolkitHint explains how to get access to secrets when NetworkManager
+// answers with ErrPermissionDenied.
+const PolkitHint = `NetworkManager only hands out secrets to clients
+authorized by polkit. System connections require the action
+  org.freedesktop.NetworkManager.settings.modify.system
+connections owned by the user the action
+  org.freedesktop.NetworkManager.settings.modify.own
+Run as root, from an active local session, or grant the
+action with a polkit rule.`
+
+func dbusErrorName(err error) string {
+	switch e := err.(type) {
+	case dbus.Error:
+		return e.Name
+	case *dbus.Error:
+		return e.Name
+	}
+	return ""
+}
+
+// classifyDbusError wraps errors from NetworkManager dbus calls into the
+// package's sentinel errors, as far as the error name allows.
+func classifyDbusError(err error) error {
+	if nil == err {
+		return nil
+	}
+	name := dbusErrorName(err)
+	switch {
+	case strings.HasSuffix(name, ".PermissionDenied"), name == "org.freedesktop.DBus.Error.AccessDenied":
+		return fmt.Errorf("%w: %v", ErrPermissionDenied, err)
+	case strings.HasSuffix(name, ".NoSecrets"):
+		return fmt.Errorf("%w: %v", ErrNoSecret, err)
+	case name == "org.freedesktop.DBus.Error.UnknownObject", name == "org.freedesktop.DBus.Error.UnknownMethod", name == "org.freedesktop.DBus.Error.UnknownInterface", strings.HasSuffix(name, ".InvalidConnection"):
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
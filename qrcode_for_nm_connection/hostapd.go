@@ -0,0 +1,207 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// hostapdBss is the configuration of one BSS of a hostapd.conf.
+type hostapdBss struct {
+	iface string
+	// settings are the raw values, later ones replace earlier ones
+	settings map[string]string
+}
+
+// ParseHostapd reads the networks a hostapd.conf provides, the first BSS
+// and those of bss= sections. Radio settings (hw_mode, channel) apply to
+// all of them. Networks are named by their SSID, those with an unsupported
+// key management are marked Unsupported.
+func ParseHostapd(r io.Reader) ([]NetworkSetting, error) {
+	radio := map[string]string{}
+	bsss := []*hostapdBss{{settings: map[string]string{}}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		// values are taken as they are, hostapd does not strip blanks
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(text, "=")
+		if !found {
+			return nil, fmt.Errorf("reading hostapd.conf: line %d: expected key=value, got %q", line, text)
+		}
+		switch key {
+		case "bss":
+			bsss = append(bsss, &hostapdBss{iface: value, settings: map[string]string{}})
+		case "interface":
+			bsss[len(bsss)-1].iface = value
+		case "hw_mode", "channel":
+			radio[key] = value
+		default:
+			bsss[len(bsss)-1].settings[key] = value
+		}
+	}
+	if err := scanner.Err(); nil != err {
+		return nil, fmt.Errorf("reading hostapd.conf: %v", err)
+	}
+	var retval []NetworkSetting
+	for _, bss := range bsss {
+		ns, err := hostapdSetting(bss, radio)
+		if nil != err {
+			return nil, fmt.Errorf("reading hostapd.conf: bss %s: %v", bss.iface, err)
+		}
+		retval = append(retval, ns)
+	}
+	return retval, nil
+}
+
+// hostapdString decodes a value given as "quoted" string, P"escaped"
+// string or in hex, like ssid2 and wep_key0.
+func hostapdString(value string) ([]byte, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return []byte(value[1 : len(value)-1]), nil
+	case len(value) >= 3 && strings.HasPrefix(value, `P"`) && value[len(value)-1] == '"':
+		s, err := strconv.Unquote(value[1:])
+		if nil != err {
+			return nil, fmt.Errorf("invalid escaped string %s", value)
+		}
+		return []byte(s), nil
+	}
+	decoded, err := hex.DecodeString(value)
+	if nil != err {
+		return nil, fmt.Errorf("invalid hex string %s", value)
+	}
+	return decoded, nil
+}
+
+func hostapdSetting(bss *hostapdBss, radio map[string]string) (NetworkSetting, error) {
+	var ns NetworkSetting
+	s := bss.settings
+	ns.Ssid = []byte(s["ssid"])
+	if ssid2, found := s["ssid2"]; found {
+		ssid, err := hostapdString(ssid2)
+		if nil != err {
+			return ns, fmt.Errorf("ssid2: %v", err)
+		}
+		ns.Ssid = ssid
+	}
+	if len(ns.Ssid) == 0 {
+		return ns, fmt.Errorf("no ssid")
+	}
+	ns.Id = string(ns.Ssid)
+	ns.IsHidden = s["ignore_broadcast_ssid"] != "" && s["ignore_broadcast_ssid"] != "0"
+	ns.Connection = ConnectionSettings{Id: ns.Id, Type: "802-11-wireless", InterfaceName: bss.iface, Autoconnect: true}
+	ns.Wireless = WirelessSettings{Ssid: ns.Ssid, Mode: "infrastructure", Hidden: ns.IsHidden}
+	switch radio["hw_mode"] {
+	case "a":
+		ns.Wireless.Band = "a"
+	case "b", "g":
+		ns.Wireless.Band = "bg"
+	}
+	if channel, err := strconv.ParseUint(radio["channel"], 10, 32); nil == err {
+		ns.Wireless.Channel = uint32(channel)
+	}
+	if bssid, found := s["bssid"]; found {
+		mac, err := net.ParseMAC(bssid)
+		if nil != err {
+			return ns, fmt.Errorf("bssid: %v", err)
+		}
+		ns.Wireless.Bssid = mac
+	}
+	switch s["ieee80211w"] {
+	case "1":
+		ns.Security.Pmf = PmfOptional
+	case "2":
+		ns.Security.Pmf = PmfRequired
+	}
+
+	wpa := s["wpa"] != "" && s["wpa"] != "0"
+	keyMgmt := strings.Fields(s["wpa_key_mgmt"])
+	has := func(name string) bool {
+		for _, k := range keyMgmt {
+			if k == name {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case wpa && (has("WPA-EAP") || has("WPA-EAP-SHA256") || has("FT-EAP")) || !wpa && s["ieee8021x"] == "1":
+		ns.Sec, ns.Security.KeyMgmt = "WPA", "wpa-eap"
+		if !wpa {
+			ns.Sec, ns.Security.KeyMgmt = "WEP", "ieee8021x"
+		}
+	case wpa && has("OWE"):
+		ns.Security.KeyMgmt = "owe"
+	case wpa && (len(keyMgmt) == 0 || has("WPA-PSK") || has("WPA-PSK-SHA256") || has("FT-PSK") || has("SAE") || has("FT-SAE")):
+		// phones join WPA2/WPA3 transition networks with either
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "wpa-psk"
+		if len(keyMgmt) > 0 && !has("WPA-PSK") && !has("WPA-PSK-SHA256") && !has("FT-PSK") {
+			ns.Security.KeyMgmt = "sae"
+		}
+		// parameters like |id= follow the password
+		sae, _, _ := strings.Cut(s["sae_password"], "|")
+		ns.Key = s["wpa_passphrase"]
+		if sae != "" && (ns.Key == "" || ns.Security.KeyMgmt == "sae") {
+			// SAE uses sae_password before wpa_passphrase
+			ns.Key = sae
+		}
+		if ns.Key == "" {
+			ns.Key = s["wpa_psk"]
+		}
+	case wpa:
+		// listed as unsupported, the other networks can still be used
+		ns.Unsupported = "wpa_key_mgmt " + s["wpa_key_mgmt"]
+	default:
+		index := s["wep_default_key"]
+		if index == "" {
+			index = "0"
+		}
+		wep, found := s["wep_key"+index]
+		if !found {
+			break
+		}
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WEP", true, "none"
+		if idx, err := strconv.ParseUint(index, 10, 32); nil == err {
+			ns.Security.WepTxKeyidx = uint32(idx)
+		}
+		if strings.HasPrefix(wep, `"`) {
+			key, err := hostapdString(wep)
+			if nil != err {
+				return ns, fmt.Errorf("wep_key%s: %v", index, err)
+			}
+			ns.Key = string(key)
+		} else {
+			// hex keys are given to the phone as they are
+			ns.Key = wep
+		}
+	}
+	if ns.Key != "" {
+		ns.KeySource = SecretSourceFile
+	}
+	return ns, nil
+}
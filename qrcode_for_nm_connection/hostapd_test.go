@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const hostapdConf = `# main network and a hidden guest network on the same radio
+interface=wlan0
+driver=nl80211
+hw_mode=a
+channel=36
+ssid=Office
+wpa=2
+wpa_key_mgmt=WPA-PSK SAE
+wpa_passphrase=office secret
+ieee80211w=1
+
+bss=wlan0_1
+ssid2=P"Guest\x21"
+ignore_broadcast_ssid=1
+wpa=2
+wpa_key_mgmt=SAE
+wpa_passphrase=not for sae
+sae_password=guestpass|id=guests
+
+bss=wlan0_2
+ssid=legacy
+wep_default_key=1
+wep_key1="abcde"
+
+bss=wlan0_3
+ssid2=6f70656e
+
+bss=wlan0_4
+ssid=suiteb
+wpa=2
+wpa_key_mgmt=WPA-EAP-SUITE-B-192
+`
+
+func TestParseHostapd(t *testing.T) {
+	parsed, err := ParseHostapd(strings.NewReader(hostapdConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 5 {
+		t.Fatalf("expected five networks, got %d", len(parsed))
+	}
+	office, guest, legacy, open, suiteb := parsed[0], parsed[1], parsed[2], parsed[3], parsed[4]
+	if office.Id != "Office" || office.Security.KeyMgmt != "wpa-psk" || office.Key != "office secret" || office.Wireless.Band != "a" || office.Wireless.Channel != 36 || office.Security.Pmf != PmfOptional {
+		t.Errorf("unexpected network %+v", office)
+	}
+	if string(guest.Ssid) != "Guest!" || !guest.IsHidden || guest.Security.KeyMgmt != "sae" || guest.Key != "guestpass" || guest.Connection.InterfaceName != "wlan0_1" {
+		t.Errorf("unexpected network %+v", guest)
+	}
+	if legacy.Sec != "WEP" || legacy.Key != "abcde" || legacy.Security.WepTxKeyidx != 1 {
+		t.Errorf("unexpected network %+v", legacy)
+	}
+	if string(open.Ssid) != "open" || open.IsPsk || open.Security.KeyMgmt != "" || open.Key != "" {
+		t.Errorf("unexpected network %+v", open)
+	}
+	for _, ns := range parsed[:4] {
+		if err := ns.CheckType(); err != nil {
+			t.Errorf("%s: %v", ns.Id, err)
+		}
+	}
+	if err := suiteb.CheckType(); !errors.Is(err, ErrUnsupportedType) || suiteb.Id != "suiteb" {
+		t.Errorf("expected %s to be unsupported, got %v", suiteb.Id, err)
+	}
+	if NetworkCode(guest) != NetworkCode(NetworkSetting{Ssid: []byte("Guest!"), Sec: "WPA", IsPsk: true, IsHidden: true, Key: "guestpass"}) {
+		t.Errorf("unexpected code %s", NetworkCode(guest))
+	}
+
+	for _, conf := range []string{"ssid\n", "bss=wlan0_1\n", "ssid2=nothex\n"} {
+		if _, err := ParseHostapd(strings.NewReader(conf)); err == nil {
+			t.Errorf("expected an error for %q", conf)
+		}
+	}
+}
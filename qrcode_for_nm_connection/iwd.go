@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// iwd phase 2 methods of PEAP for NetworkManager's phase2-auth
+var iwdPeapPhase2 = map[string]string{
+	"mschapv2": "MSCHAPV2",
+	"md5":      "MD5",
+	"gtc":      "GTC",
+}
+
+// iwd phase 2 methods of TTLS for NetworkManager's phase2-auth, EAP
+// methods inside TTLS (phase2-autheap) are named like those of PEAP
+var iwdTtlsPhase2 = map[string]string{
+	"pap":      "Tunneled-PAP",
+	"chap":     "Tunneled-CHAP",
+	"mschap":   "Tunneled-MSCHAP",
+	"mschapv2": "Tunneled-MSCHAPv2",
+}
+
+// iwdExtension returns the extension iwd uses for the security type of a
+// connection.
+func iwdExtension(ns NetworkSetting) (string, error) {
+	switch s := ns.Security.KeyMgmt; {
+	case s == "" || s == "owe":
+		return ".open", nil
+	case s == "wpa-psk" || s == "sae":
+		return ".psk", nil
+	case s == "wpa-eap":
+		return ".8021x", nil
+	}
+	return "", fmt.Errorf("%w: iwd does not support key management %s of %s", ErrUnsupportedType, ns.Security.KeyMgmt, ns.Id)
+}
+
+// IwdFilename is the name iwd expects for the profile of a connection in
+// /var/lib/iwd: the SSID if it consists of letters, digits, spaces, "-"
+// and "_", otherwise "=" and the SSID in hex, and the security type as
+// extension.
+func IwdFilename(ns NetworkSetting) string {
+	name := string(ns.Ssid)
+	for _, c := range ns.Ssid {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == ' ' || c == '-' || c == '_') {
+			name = "=" + hex.EncodeToString(ns.Ssid)
+			break
+		}
+	}
+	extension, err := iwdExtension(ns)
+	if nil != err {
+		extension = ".psk"
+	}
+	return name + extension
+}
+
+// Iwd renders a Wi-Fi connection as iwd network profile, to be stored
+// under the name IwdFilename returns.
+func Iwd(ns NetworkSetting) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if ns.IsWireGuard() {
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	if _, err := iwdExtension(ns); nil != err {
+		return nil, err
+	}
+	security := &keyfileSection{name: "Security"}
+	var embedded []string
+	switch {
+	case ns.IsPsk:
+		if _, err := hex.DecodeString(ns.Key); nil == err && len(ns.Key) == 64 {
+			security.set("PreSharedKey", ns.Key)
+		} else {
+			security.setString("Passphrase", ns.Key)
+		}
+	case ns.IsEap():
+		var err error
+		if embedded, err = iwdEap(security, ns); nil != err {
+			return nil, err
+		}
+	}
+	settings := &keyfileSection{name: "Settings"}
+	if ns.IsHidden {
+		settings.set("Hidden", "true")
+	}
+	if !ns.Connection.Autoconnect {
+		settings.set("AutoConnect", "false")
+	}
+
+	var b strings.Builder
+	writeKeyfileSections(&b, []*keyfileSection{security, settings})
+	for _, e := range embedded {
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(e)
+	}
+	return []byte(b.String()), nil
+}
+
+// iwdEap sets the 802.1x settings, it returns the embedded groups to
+// append to the profile.
+func iwdEap(security *keyfileSection, ns NetworkSetting) ([]string, error) {
+	s := ns.Ieee8021x
+	if len(s.Eap) == 0 {
+		return nil, fmt.Errorf("no EAP method for %s", ns.Id)
+	}
+	var method, phase2 string
+	switch s.Eap[0] {
+	case "peap":
+		method, phase2 = "PEAP", iwdPeapPhase2[s.Phase2Auth]
+	case "ttls":
+		method, phase2 = "TTLS", iwdTtlsPhase2[s.Phase2Auth]
+		if s.Phase2Autheap != "" {
+			phase2 = iwdPeapPhase2[s.Phase2Autheap]
+		}
+	case "pwd":
+		method = "PWD"
+	default:
+		return nil, fmt.Errorf("%w: EAP method %s of %s in iwd profiles", ErrUnsupportedType, s.Eap[0], ns.Id)
+	}
+	security.set("EAP-Method", method)
+	prefix := "EAP-" + method + "-"
+	if method == "PWD" {
+		security.setString(prefix+"Identity", s.Identity)
+		security.setString(prefix+"Password", ns.Key)
+		return nil, nil
+	}
+	if s.Phase2Auth != "" || s.Phase2Autheap != "" {
+		if phase2 == "" {
+			return nil, fmt.Errorf("%w: phase 2 authentication %s%s of %s in iwd profiles", ErrUnsupportedType, s.Phase2Auth, s.Phase2Autheap, ns.Id)
+		}
+	}
+	identity := s.AnonymousIdentity
+	if identity == "" {
+		identity = s.Identity
+	}
+	security.setString("EAP-Identity", identity)
+	var embedded []string
+	if path, isPath := s.CaCertPath(); isPath {
+		security.setString(prefix+"CACert", path)
+	} else if cert, err := ns.CaCertificate(); nil != err {
+		return nil, err
+	} else if nil != cert {
+		security.set(prefix+"CACert", "embed:ca_cert")
+		embedded = append(embedded, "[@pem@ca_cert]\n"+string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})))
+	}
+	var masks []string
+	if s.DomainMatch != "" {
+		masks = append(masks, strings.Split(s.DomainMatch, ";")...)
+	}
+	for _, suffix := range strings.Split(s.DomainSuffixMatch, ";") {
+		if suffix != "" {
+			masks = append(masks, suffix, "*."+suffix)
+		}
+	}
+	security.setString(prefix+"ServerDomainMask", strings.Join(masks, ";"))
+	security.setString(prefix+"Phase2-Method", phase2)
+	security.setString(prefix+"Phase2-Identity", s.Identity)
+	security.setString(prefix+"Phase2-Password", ns.Key)
+	return embedded, nil
+}
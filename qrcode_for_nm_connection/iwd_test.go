@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIwdFilename(t *testing.T) {
+	for ssid, want := range map[string]string{
+		"My Net_5-G":  "My Net_5-G.psk",
+		"caf\xc3\xa9": "=636166c3a9.psk",
+		"a.b":         "=612e62.psk",
+	} {
+		ns := NetworkSetting{Ssid: []byte(ssid), Security: SecuritySettings{KeyMgmt: "wpa-psk"}}
+		if got := IwdFilename(ns); got != want {
+			t.Errorf("ssid %q named %s, expected %s", ssid, got, want)
+		}
+	}
+	if got := IwdFilename(NetworkSetting{Ssid: []byte("Guest")}); got != "Guest.open" {
+		t.Errorf("open network named %s", got)
+	}
+}
+
+func TestIwd(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Key = "secret123"
+	profile, err := Iwd(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[Security]
+Passphrase=secret123
+
+[Settings]
+Hidden=true
+`
+	if string(profile) != want {
+		t.Errorf("unexpected profile:\n%s", profile)
+	}
+
+	ns.Security.KeyMgmt = "none"
+	if _, err := Iwd(ns); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected WEP to be unsupported, got %v", err)
+	}
+}
+
+func TestIwdEap(t *testing.T) {
+	ns := NetworkSetting{
+		Id:       "corp",
+		Ssid:     []byte("CorpNet"),
+		Sec:      "WPA",
+		Key:      "hunter2",
+		Security: SecuritySettings{KeyMgmt: "wpa-eap"},
+		Ieee8021x: Ieee8021xSettings{
+			Eap:               []string{"ttls"},
+			Identity:          "alice",
+			AnonymousIdentity: "anonymous",
+			Phase2Auth:        "pap",
+			CaCert:            []byte{0x30, 0x03, 0x02, 0x01, 0x01},
+			DomainSuffixMatch: "example.com",
+		},
+	}
+	ns.Connection.Autoconnect = true
+	profile, err := Iwd(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"EAP-Method=TTLS\nEAP-Identity=anonymous\nEAP-TTLS-CACert=embed:ca_cert\n",
+		"EAP-TTLS-ServerDomainMask=example.com;*.example.com\n",
+		"EAP-TTLS-Phase2-Method=Tunneled-PAP\nEAP-TTLS-Phase2-Identity=alice\nEAP-TTLS-Phase2-Password=hunter2\n",
+		"\n[@pem@ca_cert]\n-----BEGIN CERTIFICATE-----\nMAMCAQE=\n",
+	} {
+		if !strings.Contains(string(profile), want) {
+			t.Errorf("profile lacks %q:\n%s", want, profile)
+		}
+	}
+	if IwdFilename(ns) != "CorpNet.8021x" {
+		t.Errorf("unexpected file name %s", IwdFilename(ns))
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return nil == err
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateKey checks if key is an acceptable pre-shared key for the security
+// type sec ("WPA" or "WEP").
+//
+// WPA passphrases consist of 8 to 63 printable ASCII characters, a raw WPA
+// key of 64 hexadecimal digits. WEP keys are 5 or 13 ASCII characters or 10
+// or 26 hexadecimal digits.
+func ValidateKey(sec, key string) error {
+	switch sec {
+	case "WPA":
+		if len(key) == 64 && isHex(key) {
+			return nil
+		}
+		if !isPrintableASCII(key) {
+			return fmt.Errorf("%w: WPA passphrases must consist of printable ASCII characters", ErrInvalidKey)
+		}
+		if len(key) < 8 || len(key) > 63 {
+			return fmt.Errorf("%w: WPA passphrases must be 8 to 63 characters long, got %d", ErrInvalidKey, len(key))
+		}
+		return nil
+	case "WEP":
+		if (len(key) == 10 || len(key) == 26) && isHex(key) {
+			return nil
+		}
+		if (len(key) == 5 || len(key) == 13) && isPrintableASCII(key) {
+			return nil
+		}
+		return fmt.Errorf("%w: WEP keys must be 5 or 13 ASCII characters or 10 or 26 hex digits", ErrInvalidKey)
+	}
+	return nil
+}
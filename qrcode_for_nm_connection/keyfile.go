@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// keyfileSection is a group of a keyfile, keys are written in the order
+// they are set.
+type keyfileSection struct {
+	name string
+	keys []string
+	vals []string
+}
+
+func (s *keyfileSection) set(key, value string) {
+	s.keys = append(s.keys, key)
+	s.vals = append(s.vals, value)
+}
+
+func (s *keyfileSection) setString(key, value string) {
+	if value != "" {
+		s.set(key, keyfileEscape(value))
+	}
+}
+
+func (s *keyfileSection) setList(key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(strings.ReplaceAll(keyfileEscape(v), ";", `\;`))
+		b.WriteByte(';')
+	}
+	s.set(key, b.String())
+}
+
+func (s *keyfileSection) setUint(key string, value uint32) {
+	if value != 0 {
+		s.set(key, strconv.FormatUint(uint64(value), 10))
+	}
+}
+
+func (s *keyfileSection) setMac(key string, mac []byte) {
+	if len(mac) > 0 {
+		s.set(key, strings.ToUpper(net.HardwareAddr(mac).String()))
+	}
+}
+
+// setSecret writes a secret inline. Without the secret its flags are kept,
+// with it they are dropped since the secret then is system owned.
+func (s *keyfileSection) setSecret(key, secret, flagsKey string, flags SecretFlags) {
+	if secret != "" {
+		s.setString(key, secret)
+	} else if flags != SecretFlagNone {
+		s.set(flagsKey, strconv.FormatUint(uint64(flags), 10))
+	}
+}
+
+// keyfileEscape escapes a value like GLib's g_key_file_set_string.
+func keyfileEscape(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case c == ' ' && i == 0:
+			b.WriteString(`\s`)
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// keyfileSsid writes an SSID like NetworkManager: printable SSIDs as
+// string with escaped semicolons, others as list of bytes.
+func keyfileSsid(ssid []byte) string {
+	for _, c := range ssid {
+		if c < 0x20 || c > 0x7e {
+			var b strings.Builder
+			for _, c := range ssid {
+				fmt.Fprintf(&b, "%d;", c)
+			}
+			return b.String()
+		}
+	}
+	return strings.ReplaceAll(keyfileEscape(string(ssid)), ";", `\;`)
+}
+
+// NewUUID returns a random (version 4) UUID for a new connection.
+func NewUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); nil != err {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
+
+// Keyfile renders a Wi-Fi or WireGuard connection as NetworkManager
+// keyfile (.nmconnection) with its secrets inline, for
+// /etc/NetworkManager/system-connections of another machine. The UUID is
+// kept, a connection without one gets a new one. Bindings to a network
+// device of this machine (interface-name, mac-address) are left out.
+func Keyfile(ns NetworkSetting) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	uuid := ns.Uuid
+	if uuid == "" {
+		var err error
+		if uuid, err = NewUUID(); nil != err {
+			return nil, err
+		}
+	}
+	id := ns.Id
+	if id == "" {
+		id = string(ns.Ssid)
+	}
+
+	connection := &keyfileSection{name: "connection"}
+	connection.setString("id", id)
+	connection.set("uuid", uuid)
+	sections := []*keyfileSection{connection}
+	if ns.IsWireGuard() {
+		connection.set("type", "wireguard")
+		sections = append(sections, keyfileWireGuard(ns)...)
+	} else {
+		connection.set("type", "wifi")
+		sections = append(sections, keyfileWireless(ns)...)
+	}
+	if !ns.Connection.Autoconnect {
+		connection.set("autoconnect", "false")
+	}
+	if p := ns.Connection.AutoconnectPriority; p != 0 {
+		connection.set("autoconnect-priority", strconv.Itoa(int(p)))
+	}
+	connection.setList("permissions", ns.Connection.Permissions)
+	sections = append(sections, keyfileIP("ipv4", ns.Ipv4), keyfileIP("ipv6", ns.Ipv6))
+
+	var b strings.Builder
+	writeKeyfileSections(&b, sections)
+	return []byte(b.String()), nil
+}
+
+// writeKeyfileSections writes the non-empty sections separated by blank
+// lines.
+func writeKeyfileSections(b *strings.Builder, sections []*keyfileSection) {
+	for _, s := range sections {
+		if len(s.keys) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(b, "[%s]\n", s.name)
+		for j, key := range s.keys {
+			fmt.Fprintf(b, "%s=%s\n", key, s.vals[j])
+		}
+	}
+}
+
+func keyfileWireless(ns NetworkSetting) []*keyfileSection {
+	w := ns.Wireless
+	wifi := &keyfileSection{name: "wifi"}
+	mode := w.Mode
+	if mode == "" {
+		mode = "infrastructure"
+	}
+	wifi.set("mode", mode)
+	wifi.set("ssid", keyfileSsid(ns.Ssid))
+	if ns.IsHidden {
+		wifi.set("hidden", "true")
+	}
+	wifi.setString("band", w.Band)
+	wifi.setUint("channel", w.Channel)
+	wifi.setMac("bssid", w.Bssid)
+	wifi.setString("cloned-mac-address", w.AssignedMacAddress)
+	wifi.setUint("mtu", w.Mtu)
+	wifi.setUint("powersave", w.Powersave)
+
+	sec := ns.Security
+	if sec.KeyMgmt == "" {
+		return []*keyfileSection{wifi}
+	}
+	security := &keyfileSection{name: "wifi-security"}
+	security.set("key-mgmt", sec.KeyMgmt)
+	security.setString("auth-alg", sec.AuthAlg)
+	security.setList("proto", sec.Proto)
+	security.setList("pairwise", sec.Pairwise)
+	security.setList("group", sec.Group)
+	if sec.Pmf != PmfDefault {
+		security.set("pmf", strconv.Itoa(int(sec.Pmf)))
+	}
+	switch {
+	case sec.KeyMgmt == "none":
+		security.setUint("wep-tx-keyidx", sec.WepTxKeyidx)
+		security.setSecret(fmt.Sprintf("wep-key%d", sec.WepTxKeyidx), ns.Key, "wep-key-flags", sec.WepKeyFlags)
+		security.setUint("wep-key-type", sec.WepKeyType)
+	case ns.IsPsk:
+		security.setSecret("psk", ns.Key, "psk-flags", sec.PskFlags)
+	}
+	if !ns.IsEap() {
+		return []*keyfileSection{wifi, security}
+	}
+
+	s := ns.Ieee8021x
+	ieee8021x := &keyfileSection{name: "802-1x"}
+	ieee8021x.setList("eap", s.Eap)
+	ieee8021x.setString("identity", s.Identity)
+	ieee8021x.setString("anonymous-identity", s.AnonymousIdentity)
+	ieee8021x.setString("phase2-auth", s.Phase2Auth)
+	ieee8021x.setString("phase2-autheap", s.Phase2Autheap)
+	if path, isPath := s.CaCertPath(); isPath {
+		ieee8021x.setString("ca-cert", path)
+	} else if len(s.CaCert) > 0 {
+		ieee8021x.set("ca-cert", "data:;base64,"+base64.StdEncoding.EncodeToString(s.CaCert))
+	}
+	ieee8021x.setString("domain-suffix-match", s.DomainSuffixMatch)
+	ieee8021x.setString("domain-match", s.DomainMatch)
+	ieee8021x.setSecret("password", ns.Key, "password-flags", s.PasswordFlags)
+	return []*keyfileSection{wifi, security, ieee8021x}
+}
+
+func keyfileWireGuard(ns NetworkSetting) []*keyfileSection {
+	wg := ns.WireGuard
+	wireguard := &keyfileSection{name: "wireguard"}
+	wireguard.setSecret("private-key", ns.Key, "private-key-flags", wg.PrivateKeyFlags)
+	wireguard.setUint("listen-port", wg.ListenPort)
+	wireguard.setUint("fwmark", wg.Fwmark)
+	wireguard.setUint("mtu", wg.Mtu)
+	sections := []*keyfileSection{wireguard}
+	for _, peer := range wg.Peers {
+		p := &keyfileSection{name: "wireguard-peer." + peer.PublicKey}
+		p.setString("endpoint", peer.Endpoint)
+		p.setUint("persistent-keepalive", peer.PersistentKeepalive)
+		p.setSecret("preshared-key", peer.PresharedKey, "preshared-key-flags", peer.PresharedKeyFlags)
+		p.setList("allowed-ips", peer.AllowedIps)
+		sections = append(sections, p)
+	}
+	return sections
+}
+
+func keyfileIP(name string, ip IPSettings) *keyfileSection {
+	s := &keyfileSection{name: name}
+	method := ip.Method
+	if method == "" {
+		method = "auto"
+	}
+	s.set("method", method)
+	for i, address := range ip.Addresses {
+		s.set(fmt.Sprintf("address%d", i+1), address)
+	}
+	s.setString("gateway", ip.Gateway)
+	s.setList("dns", ip.Dns)
+	s.setList("dns-search", ip.DnsSearch)
+	return s
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestKeyfile(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Key = "secret;1"
+	ns.Wireless.MacAddress = []byte{0, 1, 2, 3, 4, 5}
+	keyfile, err := Keyfile(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[connection]
+id=say "hello"
+uuid=uuid-1
+type=wifi
+
+[wifi]
+mode=infrastructure
+ssid=Net
+hidden=true
+
+[wifi-security]
+key-mgmt=wpa-psk
+proto=rsn;
+pmf=3
+psk=secret;1
+
+[ipv4]
+method=auto
+
+[ipv6]
+method=auto
+`
+	if string(keyfile) != want {
+		t.Errorf("unexpected keyfile:\n%s", keyfile)
+	}
+
+	ns.Uuid = ""
+	ns.Key = ""
+	keyfile, err = Keyfile(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`\nuuid=[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\n`).Match(keyfile) {
+		t.Errorf("expected a new uuid:\n%s", keyfile)
+	}
+	if !strings.Contains(string(keyfile), "\npsk-flags=1\n") {
+		t.Errorf("expected the secret flags without the secret:\n%s", keyfile)
+	}
+}
+
+func TestKeyfileSsid(t *testing.T) {
+	for ssid, want := range map[string]string{
+		"plain":       "plain",
+		`a;b\c`:       `a\;b\\c`,
+		" lead":       `\slead`,
+		"caf\xc3\xa9": "99;97;102;195;169;",
+		"\x00x":       "0;120;",
+	} {
+		if got := keyfileSsid([]byte(ssid)); got != want {
+			t.Errorf("ssid %q written as %s, expected %s", ssid, got, want)
+		}
+	}
+}
+
+func TestKeyfileWireGuard(t *testing.T) {
+	ns := NetworkSetting{
+		Id:         "vpn",
+		Uuid:       "uuid-2",
+		Key:        "cHJpdmF0ZQ==",
+		Connection: ConnectionSettings{Id: "vpn", Type: "wireguard", InterfaceName: "wg0"},
+		WireGuard: WireGuardSettings{Peers: []WireGuardPeer{{
+			PublicKey:  "cGVlcg==",
+			Endpoint:   "vpn.example.com:51820",
+			AllowedIps: []string{"0.0.0.0/0", "::/0"},
+		}}},
+		Ipv4: IPSettings{Method: "manual", Addresses: []string{"10.0.0.2/32"}, Dns: []string{"10.0.0.1"}},
+		Ipv6: IPSettings{Method: "ignore"},
+	}
+	keyfile, err := Keyfile(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"type=wireguard\nautoconnect=false\n",
+		"[wireguard]\nprivate-key=cHJpdmF0ZQ==\n",
+		"[wireguard-peer.cGVlcg==]\nendpoint=vpn.example.com:51820\nallowed-ips=0.0.0.0/0;::/0;\n",
+		"[ipv4]\nmethod=manual\naddress1=10.0.0.2/32\ndns=10.0.0.1;\n",
+	} {
+		if !strings.Contains(string(keyfile), want) {
+			t.Errorf("keyfile lacks %q:\n%s", want, keyfile)
+		}
+	}
+	if strings.Contains(string(keyfile), "wg0") {
+		t.Errorf("keyfile should not bind to the interface:\n%s", keyfile)
+	}
+}
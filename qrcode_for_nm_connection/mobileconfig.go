@@ -0,0 +1,407 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	wifiPayloadType = "com.apple.wifi.managed"
+	rootPayloadType = "com.apple.security.root"
+)
+
+// EAP type numbers used by Apple's AcceptEAPTypes for NetworkManager's eap
+// methods
+var appleEapTypes = map[string]int{
+	"tls":  13,
+	"leap": 17,
+	"sim":  18,
+	"ttls": 21,
+	"aka":  23,
+	"peap": 25,
+	"fast": 43,
+	"pwd":  52,
+}
+
+// TTLSInnerAuthentication values for NetworkManager's phase2-auth
+var appleInnerAuth = map[string]string{
+	"pap":      "PAP",
+	"chap":     "CHAP",
+	"mschap":   "MSCHAP",
+	"mschapv2": "MSCHAPv2",
+}
+
+// nameUUID derives a (version 5 like) UUID from a name, so that exporting
+// a connection twice gives the same profile identifiers.
+func nameUUID(name string) string {
+	h := sha1.Sum([]byte(name))
+	h[6] = h[6]&0x0f | 0x50
+	h[8] = h[8]&0x3f | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16]))
+}
+
+// CaCertificate returns the DER encoded CA certificate of an 802.1x
+// connection, reading it from disk if the connection refers to a file. It
+// returns nil if there is none.
+func (ns NetworkSetting) CaCertificate() ([]byte, error) {
+	cert := ns.Ieee8021x.CaCert
+	if path, isPath := ns.Ieee8021x.CaCertPath(); isPath {
+		var err error
+		if cert, err = os.ReadFile(path); nil != err {
+			return nil, fmt.Errorf("reading CA certificate of %s: %v", ns.Id, err)
+		}
+	}
+	if block, _ := pem.Decode(cert); nil != block {
+		return block.Bytes, nil
+	}
+	if len(cert) == 0 {
+		return nil, nil
+	}
+	return cert, nil
+}
+
+func appleEncryptionType(ns NetworkSetting) (string, error) {
+	switch {
+	case ns.IsWep() || ns.Security.KeyMgmt == "ieee8021x":
+		return "WEP", nil
+	case ns.Security.KeyMgmt == "sae":
+		return "WPA3", nil
+	case ns.Sec == "WPA":
+		return "WPA", nil
+	case ns.Security.KeyMgmt == "" || ns.Security.KeyMgmt == "owe":
+		return "None", nil
+	}
+	return "", fmt.Errorf("%w: configuration profiles with key-mgmt %s (%s) are not supported", ErrUnsupportedType, ns.Security.KeyMgmt, ns.Id)
+}
+
+// Mobileconfig renders a Wi-Fi connection as unsigned Apple configuration
+// profile (.mobileconfig) with a com.apple.wifi.managed payload. iOS and
+// macOS install these for networks a QR code cannot describe, such as WPA
+// enterprise networks.
+func Mobileconfig(ns NetworkSetting) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if ns.IsWireGuard() {
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	id := ns.Uuid
+	if id == "" {
+		id = string(ns.Ssid)
+	}
+	// keep the connection's uuid, so that importing the profile gives it back
+	wifiUUID := strings.ToUpper(ns.Uuid)
+	if len(ns.Uuid) != 36 {
+		wifiUUID = nameUUID("wifi:" + id)
+	}
+	encryption, err := appleEncryptionType(ns)
+	if nil != err {
+		return nil, err
+	}
+	wifi := plistDict{
+		{"AutoJoin", ns.Connection.Autoconnect},
+		{"EncryptionType", encryption},
+		{"HIDDEN_NETWORK", ns.IsHidden},
+		{"SSID_STR", string(ns.Ssid)},
+	}
+	var payloads []interface{}
+	if ns.IsEap() {
+		eap, root, err := appleEapConfiguration(ns, id)
+		if nil != err {
+			return nil, err
+		}
+		wifi = append(wifi, plistEntry{"EAPClientConfiguration", eap})
+		if nil != root {
+			payloads = append(payloads, root)
+		}
+	} else if ns.IsPsk && ns.Key != "" {
+		wifi = append(wifi, plistEntry{"Password", ns.Key})
+	}
+	wifi = append(wifi,
+		plistEntry{"PayloadDisplayName", ns.Id},
+		plistEntry{"PayloadIdentifier", wifiPayloadType + "." + wifiUUID},
+		plistEntry{"PayloadType", wifiPayloadType},
+		plistEntry{"PayloadUUID", wifiUUID},
+		plistEntry{"PayloadVersion", 1},
+	)
+	payloads = append(payloads, wifi)
+
+	profileUUID := nameUUID("profile:" + id)
+	profile := plistDict{
+		{"PayloadContent", payloads},
+		{"PayloadDisplayName", ns.Id},
+		{"PayloadIdentifier", "org.freedesktop.NetworkManager." + profileUUID},
+		{"PayloadRemovalDisallowed", false},
+		{"PayloadType", "Configuration"},
+		{"PayloadUUID", profileUUID},
+		{"PayloadVersion", 1},
+	}
+	var b bytes.Buffer
+	if err := writePlist(&b, profile); nil != err {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// appleEapConfiguration builds the EAPClientConfiguration dictionary and,
+// if the connection has a CA certificate, the payload holding it.
+func appleEapConfiguration(ns NetworkSetting, id string) (plistDict, plistDict, error) {
+	s := ns.Ieee8021x
+	var types []interface{}
+	for _, method := range s.Eap {
+		t, found := appleEapTypes[method]
+		if !found {
+			return nil, nil, fmt.Errorf("EAP method %s of %s is not supported by Apple devices", method, ns.Id)
+		}
+		types = append(types, t)
+	}
+	eap := plistDict{{"AcceptEAPTypes", types}}
+	if s.Identity != "" {
+		eap = append(eap, plistEntry{"UserName", s.Identity})
+	}
+	if ns.Key != "" {
+		eap = append(eap, plistEntry{"UserPassword", ns.Key})
+	}
+	if s.AnonymousIdentity != "" {
+		eap = append(eap, plistEntry{"OuterIdentity", s.AnonymousIdentity})
+	}
+	if inner, found := appleInnerAuth[s.Phase2Auth]; found {
+		eap = append(eap, plistEntry{"TTLSInnerAuthentication", inner})
+	} else if s.Phase2Autheap != "" {
+		eap = append(eap, plistEntry{"TTLSInnerAuthentication", "EAP"})
+	}
+	var servers []string
+	for _, domain := range strings.Split(s.DomainSuffixMatch, ";") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			servers = append(servers, domain, "*."+domain)
+		}
+	}
+	if len(servers) > 0 {
+		eap = append(eap, plistEntry{"TLSTrustedServerNames", servers})
+	}
+
+	cert, err := ns.CaCertificate()
+	if nil != err || nil == cert {
+		return eap, nil, err
+	}
+	rootUUID := nameUUID("ca:" + id)
+	eap = append(eap, plistEntry{"PayloadCertificateAnchorUUID", []string{rootUUID}})
+	root := plistDict{
+		{"PayloadCertificateFileName", ns.Id + "-ca.cer"},
+		{"PayloadContent", cert},
+		{"PayloadDisplayName", "CA of " + ns.Id},
+		{"PayloadIdentifier", rootPayloadType + "." + rootUUID},
+		{"PayloadType", rootPayloadType},
+		{"PayloadUUID", rootUUID},
+		{"PayloadVersion", 1},
+	}
+	return eap, root, nil
+}
+
+// plistValues reads typed values from a property list dictionary, the first
+// error is kept.
+type plistValues struct {
+	dict map[string]interface{}
+	err  error
+}
+
+func (p *plistValues) get(key string, v interface{}) bool {
+	value, found := p.dict[key]
+	if !found || nil != p.err {
+		return false
+	}
+	ok := false
+	switch v := v.(type) {
+	case *string:
+		*v, ok = value.(string)
+	case *bool:
+		*v, ok = value.(bool)
+	case *int64:
+		*v, ok = value.(int64)
+	case *[]byte:
+		*v, ok = value.([]byte)
+	case *[]interface{}:
+		*v, ok = value.([]interface{})
+	case *map[string]interface{}:
+		*v, ok = value.(map[string]interface{})
+	}
+	if !ok {
+		p.err = fmt.Errorf("%s has unexpected type %T", key, value)
+	}
+	return ok
+}
+
+// ParseMobileconfig reads the Wi-Fi payloads of an unsigned configuration
+// profile. Passwords are returned as Key with SecretSourceFile.
+func ParseMobileconfig(r io.Reader) ([]NetworkSetting, error) {
+	root, err := readPlist(r)
+	if nil != err {
+		return nil, err
+	}
+	profile, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("configuration profile is no dictionary")
+	}
+	p := plistValues{dict: profile}
+	var content []interface{}
+	p.get("PayloadContent", &content)
+	if nil != p.err {
+		return nil, p.err
+	}
+	certificates := make(map[string][]byte)
+	var wifis []map[string]interface{}
+	for _, c := range content {
+		payload, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("payload is no dictionary")
+		}
+		pp := plistValues{dict: payload}
+		var payloadType, payloadUUID string
+		var cert []byte
+		pp.get("PayloadType", &payloadType)
+		pp.get("PayloadUUID", &payloadUUID)
+		switch payloadType {
+		case wifiPayloadType:
+			wifis = append(wifis, payload)
+		case rootPayloadType:
+			pp.get("PayloadContent", &cert)
+			certificates[payloadUUID] = cert
+		}
+		if nil != pp.err {
+			return nil, pp.err
+		}
+	}
+	var retval []NetworkSetting
+	for _, wifi := range wifis {
+		ns, err := newMobileconfigSetting(wifi, certificates)
+		if nil != err {
+			return retval, err
+		}
+		retval = append(retval, ns)
+	}
+	return retval, nil
+}
+
+func newMobileconfigSetting(wifi map[string]interface{}, certificates map[string][]byte) (NetworkSetting, error) {
+	var ns NetworkSetting
+	p := plistValues{dict: wifi}
+	var ssid, name, uuid, encryption, password string
+	autojoin := true
+	var eap map[string]interface{}
+	if !p.get("SSID_STR", &ssid) && nil == p.err {
+		return ns, fmt.Errorf("Wi-Fi payload without SSID_STR")
+	}
+	p.get("PayloadDisplayName", &name)
+	p.get("PayloadUUID", &uuid)
+	p.get("EncryptionType", &encryption)
+	p.get("Password", &password)
+	p.get("HIDDEN_NETWORK", &ns.IsHidden)
+	p.get("AutoJoin", &autojoin)
+	p.get("EAPClientConfiguration", &eap)
+	if nil != p.err {
+		return ns, p.err
+	}
+	if name == "" {
+		name = ssid
+	}
+	ns.Id, ns.Uuid, ns.Ssid = name, strings.ToLower(uuid), []byte(ssid)
+	ns.Connection = ConnectionSettings{Id: ns.Id, Uuid: ns.Uuid, Type: "802-11-wireless", Autoconnect: autojoin}
+	ns.Wireless = WirelessSettings{Ssid: ns.Ssid, Mode: "infrastructure", Hidden: ns.IsHidden}
+
+	switch {
+	case encryption == "WEP":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WEP", true, "none"
+	case encryption == "None":
+	case nil != eap:
+		ns.Sec, ns.Security.KeyMgmt = "WPA", "wpa-eap"
+		var err error
+		if password, err = ns.addAppleEap(eap, certificates); nil != err {
+			return ns, err
+		}
+	case encryption == "WPA3":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "sae"
+	default:
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "wpa-psk"
+	}
+	ns.Key = password
+	if password != "" {
+		ns.KeySource = SecretSourceFile
+	}
+	return ns, nil
+}
+
+// addAppleEap reads an EAPClientConfiguration and returns the password.
+func (ns *NetworkSetting) addAppleEap(eap map[string]interface{}, certificates map[string][]byte) (string, error) {
+	p := plistValues{dict: eap}
+	s := &ns.Ieee8021x
+	var types, servers, anchors []interface{}
+	var inner, password string
+	p.get("AcceptEAPTypes", &types)
+	p.get("UserName", &s.Identity)
+	p.get("UserPassword", &password)
+	p.get("OuterIdentity", &s.AnonymousIdentity)
+	p.get("TTLSInnerAuthentication", &inner)
+	p.get("TLSTrustedServerNames", &servers)
+	p.get("PayloadCertificateAnchorUUID", &anchors)
+	if nil != p.err {
+		return "", fmt.Errorf("EAPClientConfiguration: %v", p.err)
+	}
+	for _, t := range types {
+		number, _ := t.(int64)
+		method := ""
+		for name, n := range appleEapTypes {
+			if int64(n) == number {
+				method = name
+			}
+		}
+		if method == "" {
+			return "", fmt.Errorf("unsupported EAP type %v", t)
+		}
+		s.Eap = append(s.Eap, method)
+	}
+	for name, apple := range appleInnerAuth {
+		if apple == inner {
+			s.Phase2Auth = name
+		}
+	}
+	var domains []string
+	seen := make(map[string]bool)
+	for _, server := range servers {
+		name, _ := server.(string)
+		name = strings.TrimPrefix(name, "*.")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			domains = append(domains, name)
+		}
+	}
+	s.DomainSuffixMatch = strings.Join(domains, ";")
+	for _, anchor := range anchors {
+		if uuid, _ := anchor.(string); nil != certificates[uuid] {
+			s.CaCert = certificates[uuid]
+		}
+	}
+	return password, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+)
+
+func TestMobileconfigPsk(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Uuid = "0b5a4f3c-6c47-4b8c-9a4f-0e4c3f1d2a10"
+	ns.Key = `pass<&>"word`
+	profile, err := Mobileconfig(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseMobileconfig(bytes.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("expected one network, got %d", len(parsed))
+	}
+	p := parsed[0]
+	if p.Id != ns.Id || p.Uuid != ns.Uuid || string(p.Ssid) != "Net" || !p.IsHidden || p.Key != ns.Key || !p.IsPsk || p.Sec != "WPA" {
+		t.Errorf("round trip changed the connection to %+v", p)
+	}
+	if NetworkCode(p) != NetworkCode(ns) {
+		t.Errorf("round trip changed the code to %s", NetworkCode(p))
+	}
+}
+
+func TestMobileconfigWep(t *testing.T) {
+	ns := settingOf(t, nmtest.WifiWep("legacy", "uuid-4", "OldNet", "abcde", 0))
+	profile, err := Mobileconfig(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(profile), "<key>EncryptionType</key>\n\t\t\t<string>WEP</string>") {
+		t.Errorf("expected WEP encryption:\n%s", profile)
+	}
+	parsed, err := ParseMobileconfig(bytes.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || NetworkCode(parsed[0]) != NetworkCode(ns) {
+		t.Errorf("round trip changed the connection to %+v", parsed)
+	}
+
+	ns.Security.KeyMgmt = "wapi-psk"
+	ns.Sec = "unknown"
+	if _, err := Mobileconfig(ns); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected an unknown key-mgmt to be unsupported, got %v", err)
+	}
+}
+
+func TestMobileconfigEap(t *testing.T) {
+	ns := NetworkSetting{
+		Id:       "corp",
+		Ssid:     []byte("CorpNet"),
+		Sec:      "WPA",
+		Key:      "hunter2",
+		Security: SecuritySettings{KeyMgmt: "wpa-eap"},
+		Ieee8021x: Ieee8021xSettings{
+			Eap:               []string{"peap"},
+			Identity:          "alice",
+			AnonymousIdentity: "anonymous",
+			Phase2Auth:        "mschapv2",
+			CaCert:            []byte{0x30, 0x03, 0x02, 0x01, 0x01},
+			DomainSuffixMatch: "radius.example.com",
+		},
+	}
+	ns.Connection.Autoconnect = true
+	profile, err := Mobileconfig(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<key>UserPassword</key>", "<integer>25</integer>", "<string>*.radius.example.com</string>", "com.apple.security.root"} {
+		if !strings.Contains(string(profile), want) {
+			t.Errorf("profile lacks %s:\n%s", want, profile)
+		}
+	}
+	parsed, err := ParseMobileconfig(bytes.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("expected one network, got %d", len(parsed))
+	}
+	p := parsed[0]
+	if !p.IsEap() || p.IsPsk || p.Key != "hunter2" {
+		t.Errorf("unexpected security %+v", p)
+	}
+	if !reflect.DeepEqual(p.Ieee8021x, ns.Ieee8021x) {
+		t.Errorf("round trip changed the 802.1x settings to %+v", p.Ieee8021x)
+	}
+
+	ns.Ieee8021x.Eap = []string{"md5"}
+	if _, err := Mobileconfig(ns); err == nil {
+		t.Errorf("expected an error for an EAP method Apple does not support")
+	}
+}
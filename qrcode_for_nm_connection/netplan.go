@@ -0,0 +1,357 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// netplanFile is a netplan configuration, which is also the version 2
+// network configuration of cloud-init.
+type netplanFile struct {
+	Network netplanNetwork `yaml:"network"`
+}
+
+type netplanNetwork struct {
+	Version int                    `yaml:"version"`
+	Wifis   map[string]netplanWifi `yaml:"wifis"`
+}
+
+type netplanWifi struct {
+	Dhcp4            bool                          `yaml:"dhcp4,omitempty"`
+	Gateway4         string                        `yaml:"gateway4,omitempty"` // deprecated, only read
+	Dhcp6            bool                          `yaml:"dhcp6,omitempty"`
+	Addresses        []string                      `yaml:"addresses,omitempty"`
+	Routes           []netplanRoute                `yaml:"routes,omitempty"`
+	Nameservers      *netplanNameservers           `yaml:"nameservers,omitempty"`
+	Optional         bool                          `yaml:"optional,omitempty"`
+	RegulatoryDomain string                        `yaml:"regulatory-domain,omitempty"`
+	AccessPoints     map[string]netplanAccessPoint `yaml:"access-points"`
+}
+
+type netplanRoute struct {
+	To  string `yaml:"to"`
+	Via string `yaml:"via"`
+}
+
+type netplanNameservers struct {
+	Addresses []string `yaml:"addresses,omitempty"`
+	Search    []string `yaml:"search,omitempty"`
+}
+
+type netplanAccessPoint struct {
+	Password string       `yaml:"password,omitempty"`
+	Mode     string       `yaml:"mode,omitempty"` // infrastructure, adhoc or ap
+	Hidden   bool         `yaml:"hidden,omitempty"`
+	Band     string       `yaml:"band,omitempty"` // 2.4GHz or 5GHz
+	Channel  uint32       `yaml:"channel,omitempty"`
+	Bssid    string       `yaml:"bssid,omitempty"`
+	Auth     *netplanAuth `yaml:"auth,omitempty"`
+}
+
+type netplanAuth struct {
+	KeyManagement     string `yaml:"key-management,omitempty"` // none, psk, sae or eap
+	Password          string `yaml:"password,omitempty"`
+	Method            string `yaml:"method,omitempty"` // tls, peap or ttls
+	Identity          string `yaml:"identity,omitempty"`
+	AnonymousIdentity string `yaml:"anonymous-identity,omitempty"`
+	CaCertificate     string `yaml:"ca-certificate,omitempty"`
+	Phase2Auth        string `yaml:"phase2-auth,omitempty"`
+}
+
+// netplan bands for NetworkManager's band
+var netplanBands = map[string]string{
+	"a":  "5GHz",
+	"bg": "2.4GHz",
+}
+
+// netplanAccessPointOf converts the wireless and security settings of a
+// connection to a netplan access point.
+func netplanAccessPointOf(ns NetworkSetting) (string, netplanAccessPoint, error) {
+	var ap netplanAccessPoint
+	if !utf8.Valid(ns.Ssid) {
+		return "", ap, fmt.Errorf("%w: netplan only takes UTF-8 SSIDs, not the one of %s", ErrUnsupportedType, ns.Id)
+	}
+	w := ns.Wireless
+	if w.Mode != "infrastructure" {
+		ap.Mode = w.Mode
+	}
+	ap.Hidden = ns.IsHidden
+	ap.Band = netplanBands[w.Band]
+	ap.Channel = w.Channel
+	if len(w.Bssid) > 0 {
+		ap.Bssid = net.HardwareAddr(w.Bssid).String()
+	}
+	switch s := ns.Security.KeyMgmt; {
+	case s == "":
+	case s == "wpa-psk":
+		ap.Password = ns.Key
+	case s == "sae":
+		ap.Auth = &netplanAuth{KeyManagement: "sae", Password: ns.Key}
+	case s == "wpa-eap" && len(ns.Ieee8021x.Eap) > 0:
+		e := ns.Ieee8021x
+		switch e.Eap[0] {
+		case "tls", "peap", "ttls":
+		default:
+			return "", ap, fmt.Errorf("%w: EAP method %s of %s in netplan", ErrUnsupportedType, e.Eap[0], ns.Id)
+		}
+		ap.Auth = &netplanAuth{
+			KeyManagement:     "eap",
+			Password:          ns.Key,
+			Method:            e.Eap[0],
+			Identity:          e.Identity,
+			AnonymousIdentity: e.AnonymousIdentity,
+			Phase2Auth:        strings.ToUpper(e.Phase2Auth),
+		}
+		if path, isPath := e.CaCertPath(); isPath {
+			ap.Auth.CaCertificate = path
+		} else if len(e.CaCert) > 0 {
+			return "", ap, fmt.Errorf("%w: the CA certificate of %s is stored by NetworkManager, netplan needs a file", ErrUnsupportedType, ns.Id)
+		}
+	default:
+		return "", ap, fmt.Errorf("%w: key management %s of %s in netplan", ErrUnsupportedType, s, ns.Id)
+	}
+	return string(ns.Ssid), ap, nil
+}
+
+// Netplan renders a Wi-Fi connection as netplan configuration for
+// /etc/netplan, for the interface the connection is bound to or wlan0.
+func Netplan(ns NetworkSetting) ([]byte, error) {
+	iface := ns.Connection.InterfaceName
+	if iface == "" {
+		iface = "wlan0"
+	}
+	return netplanConfig(ns, iface, "")
+}
+
+// netplanConfig renders a Wi-Fi connection as netplan configuration for
+// the interface, regulatoryDomain is the country code for the radio or
+// empty. The interface is optional, i.e. booting does not wait for it.
+func netplanConfig(ns NetworkSetting, iface, regulatoryDomain string) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if ns.IsWireGuard() {
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	ssid, ap, err := netplanAccessPointOf(ns)
+	if nil != err {
+		return nil, err
+	}
+	wifi := netplanWifi{
+		Optional:         true,
+		RegulatoryDomain: regulatoryDomain,
+		AccessPoints:     map[string]netplanAccessPoint{ssid: ap},
+	}
+	switch ns.Ipv4.Method {
+	case "", "auto":
+		wifi.Dhcp4 = true
+	case "manual":
+		wifi.Addresses = append(wifi.Addresses, ns.Ipv4.Addresses...)
+		if ns.Ipv4.Gateway != "" {
+			wifi.Routes = append(wifi.Routes, netplanRoute{To: "default", Via: ns.Ipv4.Gateway})
+		}
+	}
+	switch ns.Ipv6.Method {
+	case "dhcp":
+		wifi.Dhcp6 = true
+	case "manual":
+		wifi.Addresses = append(wifi.Addresses, ns.Ipv6.Addresses...)
+		if ns.Ipv6.Gateway != "" {
+			wifi.Routes = append(wifi.Routes, netplanRoute{To: "default", Via: ns.Ipv6.Gateway})
+		}
+	}
+	dns := append(append([]string{}, ns.Ipv4.Dns...), ns.Ipv6.Dns...)
+	search := append(append([]string{}, ns.Ipv4.DnsSearch...), ns.Ipv6.DnsSearch...)
+	if len(dns) > 0 || len(search) > 0 {
+		wifi.Nameservers = &netplanNameservers{Addresses: dns, Search: search}
+	}
+	config := netplanFile{Network: netplanNetwork{
+		Version: 2,
+		Wifis:   map[string]netplanWifi{iface: wifi},
+	}}
+	return marshalNetplan(config)
+}
+
+// marshalNetplan writes YAML indented by two spaces like the examples of
+// netplan.
+func marshalNetplan(config netplanFile) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); nil != err {
+		return nil, err
+	}
+	err := encoder.Close()
+	return b.Bytes(), err
+}
+
+// ParseNetplan reads the access points of the wifis of a netplan
+// configuration (or cloud-init network configuration version 2). They are
+// named like the netplan NetworkManager renderer names them, e.g.
+// netplan-wlan0-Home.
+func ParseNetplan(r io.Reader) ([]NetworkSetting, error) {
+	data, err := io.ReadAll(r)
+	if nil != err {
+		return nil, err
+	}
+	var config netplanFile
+	if err := yaml.Unmarshal(data, &config); nil != err {
+		return nil, fmt.Errorf("reading netplan: %v", err)
+	}
+	if config.Network.Version == 0 {
+		// cloud-init also takes the configuration without network:
+		if err := yaml.Unmarshal(data, &config.Network); nil != err {
+			return nil, fmt.Errorf("reading netplan: %v", err)
+		}
+	}
+	if config.Network.Version != 2 {
+		return nil, fmt.Errorf("reading netplan: version %d is not supported", config.Network.Version)
+	}
+	var retval []NetworkSetting
+	for _, iface := range sortedKeys(config.Network.Wifis) {
+		wifi := config.Network.Wifis[iface]
+		for _, ssid := range sortedKeys(wifi.AccessPoints) {
+			ns, err := netplanSetting(iface, wifi, ssid, wifi.AccessPoints[ssid])
+			if nil != err {
+				return nil, fmt.Errorf("reading netplan: %s: %v", ns.Id, err)
+			}
+			retval = append(retval, ns)
+		}
+	}
+	return retval, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func netplanSetting(iface string, wifi netplanWifi, ssid string, ap netplanAccessPoint) (NetworkSetting, error) {
+	var ns NetworkSetting
+	ns.Id = "netplan-" + iface + "-" + ssid
+	ns.Ssid = []byte(ssid)
+	ns.IsHidden = ap.Hidden
+	ns.Connection = ConnectionSettings{Id: ns.Id, Type: "802-11-wireless", Autoconnect: true}
+	mode := ap.Mode
+	if mode == "" {
+		mode = "infrastructure"
+	}
+	ns.Wireless = WirelessSettings{Ssid: ns.Ssid, Mode: mode, Hidden: ap.Hidden, Channel: ap.Channel}
+	for band, name := range netplanBands {
+		if name == ap.Band {
+			ns.Wireless.Band = band
+		}
+	}
+	if ap.Bssid != "" {
+		bssid, err := net.ParseMAC(ap.Bssid)
+		if nil != err {
+			return ns, err
+		}
+		ns.Wireless.Bssid = bssid
+	}
+
+	auth := netplanAuth{Password: ap.Password}
+	if ap.Password != "" {
+		auth.KeyManagement = "psk"
+	}
+	if nil != ap.Auth {
+		auth = *ap.Auth
+	}
+	switch auth.KeyManagement {
+	case "", "none":
+	case "psk":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "wpa-psk"
+		ns.Key = auth.Password
+	case "sae":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "sae"
+		ns.Key = auth.Password
+	case "eap", "802.1x":
+		ns.Sec, ns.Security.KeyMgmt = "WPA", "wpa-eap"
+		if auth.KeyManagement == "802.1x" {
+			ns.Sec, ns.Security.KeyMgmt = "WEP", "ieee8021x"
+		}
+		ns.Ieee8021x = Ieee8021xSettings{
+			Identity:          auth.Identity,
+			AnonymousIdentity: auth.AnonymousIdentity,
+			Phase2Auth:        strings.ToLower(auth.Phase2Auth),
+		}
+		if auth.Method != "" {
+			ns.Ieee8021x.Eap = []string{auth.Method}
+		}
+		if auth.CaCertificate != "" {
+			ns.Ieee8021x.CaCert = []byte("file://" + auth.CaCertificate + "\x00")
+		}
+		ns.Key = auth.Password
+	default:
+		return ns, fmt.Errorf("unknown key-management %q", auth.KeyManagement)
+	}
+	if ns.Key != "" {
+		ns.KeySource = SecretSourceFile
+	}
+
+	if wifi.Dhcp4 {
+		ns.Ipv4.Method = "auto"
+	}
+	if wifi.Dhcp6 {
+		ns.Ipv6.Method = "dhcp"
+	}
+	for _, address := range wifi.Addresses {
+		if strings.Contains(address, ":") {
+			ns.Ipv6.Method = "manual"
+			ns.Ipv6.Addresses = append(ns.Ipv6.Addresses, address)
+		} else {
+			ns.Ipv4.Method = "manual"
+			ns.Ipv4.Addresses = append(ns.Ipv4.Addresses, address)
+		}
+	}
+	ns.Ipv4.Gateway = wifi.Gateway4
+	for _, route := range wifi.Routes {
+		switch {
+		case route.To != "default" && route.To != "0.0.0.0/0" && route.To != "::/0":
+		case strings.Contains(route.Via, ":"):
+			ns.Ipv6.Gateway = route.Via
+		default:
+			ns.Ipv4.Gateway = route.Via
+		}
+	}
+	if nil != wifi.Nameservers {
+		for _, dns := range wifi.Nameservers.Addresses {
+			if strings.Contains(dns, ":") {
+				ns.Ipv6.Dns = append(ns.Ipv6.Dns, dns)
+			} else {
+				ns.Ipv4.Dns = append(ns.Ipv4.Dns, dns)
+			}
+		}
+		ns.Ipv4.DnsSearch = wifi.Nameservers.Search
+	}
+	return ns, nil
+}
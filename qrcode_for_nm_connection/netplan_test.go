@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// as found on an Ubuntu server, the password of Lab is read as integer
+const netplanServer = `network:
+  version: 2
+  renderer: networkd
+  wifis:
+    wlp2s0:
+      optional: true
+      addresses: [192.168.7.20/24, "2001:db8::20/64"]
+      routes:
+        - to: default
+          via: 192.168.7.1
+      nameservers:
+        addresses: [192.168.7.1]
+        search: [lab.example.com]
+      access-points:
+        "Lab":
+          password: 12345678
+        "Corp Net":
+          hidden: true
+          auth:
+            key-management: eap
+            method: peap
+            identity: alice
+            password: hunter2
+            ca-certificate: /etc/ssl/corp.pem
+            phase2-auth: MSCHAPV2
+`
+
+func TestParseNetplan(t *testing.T) {
+	parsed, err := ParseNetplan(strings.NewReader(netplanServer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 {
+		t.Fatalf("expected two networks, got %d", len(parsed))
+	}
+	corp, lab := parsed[0], parsed[1]
+	if lab.Id != "netplan-wlp2s0-Lab" || !lab.IsPsk || lab.Key != "12345678" || lab.Security.KeyMgmt != "wpa-psk" {
+		t.Errorf("unexpected network %+v", lab)
+	}
+	if lab.Ipv4.Method != "manual" || lab.Ipv4.Gateway != "192.168.7.1" || len(lab.Ipv6.Addresses) != 1 || len(lab.Ipv4.Dns) != 1 {
+		t.Errorf("unexpected addressing %+v %+v", lab.Ipv4, lab.Ipv6)
+	}
+	if !corp.IsEap() || !corp.IsHidden || corp.Key != "hunter2" || corp.Ieee8021x.Identity != "alice" || corp.Ieee8021x.Phase2Auth != "mschapv2" {
+		t.Errorf("unexpected network %+v", corp)
+	}
+	if path, isPath := corp.Ieee8021x.CaCertPath(); !isPath || path != "/etc/ssl/corp.pem" {
+		t.Errorf("unexpected CA certificate %q", corp.Ieee8021x.CaCert)
+	}
+
+	if _, err := ParseNetplan(strings.NewReader("network:\n  version: 1\n")); err == nil {
+		t.Errorf("expected version 1 to be rejected")
+	}
+}
+
+func TestNetplanRoundTrip(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Key = "secret123"
+	ns.Security.KeyMgmt = "sae"
+	config, err := Netplan(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseNetplan(bytes.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("expected one network, got %d", len(parsed))
+	}
+	p := parsed[0]
+	if p.Id != "netplan-wlan0-Net" || p.Security.KeyMgmt != "sae" || p.Key != ns.Key || NetworkCode(p) != NetworkCode(ns) {
+		t.Errorf("round trip changed the connection to %+v", p)
+	}
+}
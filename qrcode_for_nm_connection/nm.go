@@ -19,118 +19,338 @@
 package qrcode_for_nm_connection
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/godbus/dbus"
 )
 
+// SecretFlags are NetworkManager's NMSettingSecretFlags, they tell who
+// stores a secret.
+type SecretFlags uint32
+
+const (
+	SecretFlagNone        SecretFlags = 0 // stored by NetworkManager
+	SecretFlagAgentOwned  SecretFlags = 1 // stored by a secret agent (user keyring)
+	SecretFlagNotSaved    SecretFlags = 2 // asked for on every connection
+	SecretFlagNotRequired SecretFlags = 4
+)
+
 type NetworkSetting struct {
-	Ssid     []byte
-	Id       string
-	Sec      string // WPA or WEP
-	IsPsk    bool
-	IsHidden bool
-	Key      string
-	DbusId   int
-	// contents from dbus are:
-	// 802-11-wireless: map[mac-address:@ay [0xa0, 0x88, …] mac-address-blacklist:@as [] mode:"infrastructure" security:"802-11-wireless-security" ssid:@ay [0x50, …]]
-	// connection: map[permissions:["user:…"] type:"802-11-wireless" uuid:"c3…" id:"P…"]
-	// 802-11-wireless-security: map[auth-alg:"open" key-mgmt:"wpa-psk"]
+	Ssid      []byte
+	Id        string
+	Uuid      string
+	Sec       string // WPA or WEP
+	IsPsk     bool
+	IsHidden  bool
+	Key       string
+	KeySource SecretSource
+	PskFlags  SecretFlags
+	// PermissionDenied is set when NetworkManager refused to hand out
+	// the secret.
+	PermissionDenied bool
+	// Unsupported is set by the configuration file parsers for networks
+	// they read but cannot represent, e.g. for an unknown key management.
+	// CheckType reports it.
+	Unsupported string
+	DbusId      int
+	// the settings blocks as obtained from dbus, the fields above are
+	// derived from them
+	Connection ConnectionSettings
+	Wireless   WirelessSettings
+	Security   SecuritySettings
+	Ieee8021x  Ieee8021xSettings
+	WireGuard  WireGuardSettings
+	Ipv4       IPSettings
+	Ipv6       IPSettings
+}
+
+// secretsBlock is the part of the GetSecrets reply we read, see
+// decodeBlock.
+type secretsBlock struct {
+	Psk     string `dbus:"psk"`
+	WepKey0 string `dbus:"wep-key0"`
+	WepKey1 string `dbus:"wep-key1"`
+	WepKey2 string `dbus:"wep-key2"`
+	WepKey3 string `dbus:"wep-key3"`
 }
 
-func removeQuotes(s string) string {
-	if strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"") {
-		return s[1 : len(s)-1]
+// wepKey returns the WEP key with index idx, NetworkManager has four.
+func (s secretsBlock) wepKey(idx uint32) string {
+	keys := [...]string{s.WepKey0, s.WepKey1, s.WepKey2, s.WepKey3}
+	if idx >= uint32(len(keys)) {
+		return ""
 	}
-	return s
+	return keys[idx]
 }
 
 func (ns *NetworkSetting) AddNetworkSecrets(callbody interface{}) error {
-	networkSecrets := callbody.(map[string]map[string]dbus.Variant)
-
-	wifisecurity, found := networkSecrets["802-11-wireless-security"]
-	if !found {
-		return fmt.Errorf("No 802-11-wireless-security block in network Secrets")
+	networkSecrets, err := settingsMap(callbody)
+	if nil != err {
+		return err
+	}
+	if ns.IsWireGuard() {
+		return ns.addWireGuardSecrets(networkSecrets)
+	}
+	if ns.IsEap() {
+		var secrets struct {
+			Password string `dbus:"password"`
+		}
+		if _, err := decodeBlock(networkSecrets, "802-1x", &secrets); nil != err {
+			return err
+		}
+		if secrets.Password == "" {
+			return fmt.Errorf("%w: No password in 802-1x block", ErrNoSecret)
+		}
+		ns.Key = secrets.Password
+		return nil
+	}
+	var secrets secretsBlock
+	found, err := decodeBlock(networkSecrets, "802-11-wireless-security", &secrets)
+	if nil != err {
+		return err
 	}
-	networkKey, found := wifisecurity["psk"]
 	if !found {
-		return fmt.Errorf("No key in 802-11-wireless-security block")
+		return fmt.Errorf("%w in network Secrets", ErrNoSecurityBlock)
 	}
-	ns.Key = removeQuotes(networkKey.String())
+	key := secrets.Psk
+	if ns.IsWep() {
+		key = secrets.wepKey(ns.Security.WepTxKeyidx)
+	}
+	if key == "" {
+		_, settingKey, _ := ns.secret()
+		return fmt.Errorf("%w: No %s in 802-11-wireless-security block", ErrNoSecret, settingKey)
+	}
+	ns.Key = key
 	return nil
 }
 
 func NewNetworkSetting(callbody interface{}) (NetworkSetting, error) {
 	var retval NetworkSetting
-	resolved := callbody.(map[string]map[string]dbus.Variant)
+	resolved, err := settingsMap(callbody)
+	if nil != err {
+		return retval, err
+	}
+	// GetSettings omits properties with their default value
+	retval.Connection.Autoconnect = true
+	retval.Wireless.Mode = "infrastructure"
 	{
-		wifi, found := resolved["802-11-wireless"]
-		if !found {
-			return retval, fmt.Errorf("Could not resolve dbus \"802-11-wireless\" (ini \"wifi\"): %v", callbody)
+		connection := &retval.Connection
+		found, err := decodeBlock(resolved, "connection", connection)
+		if nil != err {
+			return retval, err
 		}
-		ssid, found := wifi["ssid"]
 		if !found {
-			return retval, fmt.Errorf("Could not resolve ssid. got from dbus: %v", callbody)
+			return retval, fmt.Errorf("Could not resolve \"connection\" block")
 		}
-
-		retval.Ssid = ssid.Value().([]byte)
+		retval.Id = connection.Id
+		retval.Uuid = connection.Uuid
+		if err := retval.CheckType(); nil != err {
+			return retval, err
+		}
+	}
+	if retval.IsWireGuard() {
+		return retval, retval.addWireGuardSettings(resolved)
 	}
 	{
-		connection, found := resolved["connection"]
-		if !found {
-			return retval, fmt.Errorf("Could not resolve \"connection\". got from dbus: %v", callbody)
+		wifi := &retval.Wireless
+		found, err := decodeBlock(resolved, "802-11-wireless", wifi)
+		if nil != err {
+			return retval, err
 		}
-		id, found := connection["id"]
 		if !found {
-			return retval, fmt.Errorf("Could not resolve \"id\". got from dbus: %v", callbody)
+			return retval, &UnsupportedTypeError{Id: retval.Id}
 		}
-		retval.Id = removeQuotes(id.String())
+		retval.Ssid = wifi.Ssid
+		retval.IsHidden = wifi.Hidden
+		retval.Connection.Type = "802-11-wireless"
 	}
 	{
-		wifisecurity, found := resolved["802-11-wireless-security"]
-		if !found {
-			return retval, fmt.Errorf("Could not resolve dbus \"802-11-wireless-security\" (ini \"wifi-security\"). got from dbus: %v\n", callbody)
+		wifisecurity := &retval.Security
+		found, err := decodeBlock(resolved, "802-11-wireless-security", wifisecurity)
+		if nil != err {
+			return retval, err
 		}
-		keymgmt, found := wifisecurity["key-mgmt"]
 		if !found {
-			return retval, fmt.Errorf("Could not resolve key-mgmt. got from dbus: %v\n", callbody)
-		}
-		keymgmt_string := removeQuotes(keymgmt.String())
-		if strings.HasPrefix(keymgmt_string, "wpa") {
-			retval.Sec = "WPA"
-		} else if strings.HasPrefix(keymgmt_string, "wep") {
-			retval.Sec = "WEP"
+			// no security, an open network
+			retval.Sec = "nopass"
 		} else {
-			retval.Sec = "unknown"
+			keymgmt_string := wifisecurity.KeyMgmt
+			if strings.HasPrefix(keymgmt_string, "wpa") {
+				retval.Sec = "WPA"
+			} else if keymgmt_string == "none" || keymgmt_string == "ieee8021x" {
+				// static WEP and dynamic WEP (802.1x)
+				retval.Sec = "WEP"
+			} else {
+				retval.Sec = "unknown"
+			}
+			if keymgmt_string == "sae" {
+				// WPA3 personal, phones accept it as WPA
+				retval.Sec = "WPA"
+			}
+			retval.IsPsk = strings.HasSuffix(keymgmt_string, "-psk") || keymgmt_string == "sae" || keymgmt_string == "none"
+			retval.PskFlags = wifisecurity.PskFlags
 		}
-		retval.IsPsk = strings.HasSuffix(keymgmt_string, "-psk")
 	}
-	retval.IsHidden = false // TODO: implement
+	if _, err := decodeBlock(resolved, "802-1x", &retval.Ieee8021x); nil != err {
+		return retval, err
+	}
+	if retval.Ipv4, err = decodeIPSettings(resolved, "ipv4"); nil != err {
+		return retval, err
+	}
+	retval.Ipv6, err = decodeIPSettings(resolved, "ipv6")
+	return retval, err
+}
 
-	return retval, nil
+// CheckType returns an UnsupportedTypeError if the connection is known not
+// to be a Wi-Fi or WireGuard connection, and ErrUnsupportedType for
+// networks a configuration file parser marked Unsupported.
+func (ns NetworkSetting) CheckType() error {
+	if ns.Unsupported != "" {
+		return fmt.Errorf("%w: %s has %s", ErrUnsupportedType, ns.Id, ns.Unsupported)
+	}
+	if t := ns.Connection.Type; t != "" && t != "802-11-wireless" && t != "wireguard" {
+		return &UnsupportedTypeError{Id: ns.Id, Type: t}
+	}
+	return nil
 }
 
+// DbusPath returns the object path of the settings connection on the
+// NetworkManager dbus service.
+func (ns NetworkSetting) DbusPath() dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/NetworkManager/Settings/%d", ns.DbusId))
+}
+
+// GetNetworkSettings retrieves the settings of a connection together with
+// its secrets.
 func GetNetworkSettings(settingsId int, conn *dbus.Conn) (NetworkSetting, error) {
-	connectionpathstring := fmt.Sprintf("/org/freedesktop/NetworkManager/Settings/%d", settingsId)
-	obj := conn.Object("org.freedesktop.NetworkManager", dbus.ObjectPath(connectionpathstring))
+	return GetNetworkSettingsContext(context.Background(), settingsId, conn)
+}
 
-	settings := obj.Call("org.freedesktop.NetworkManager.Settings.Connection.GetSettings", 0)
+// GetNetworkSettingsContext is GetNetworkSettings with a context which
+// bounds the dbus calls.
+func GetNetworkSettingsContext(ctx context.Context, settingsId int, conn *dbus.Conn) (NetworkSetting, error) {
+	networkSettings, err := GetSettingsContext(ctx, settingsId, conn)
+	if nil != err {
+		return networkSettings, err
+	}
+	err = networkSettings.FetchSecretsContext(ctx, conn)
+	return networkSettings, err
+}
+
+// GetSettings retrieves the settings of a connection without asking
+// NetworkManager for secrets.
+func GetSettings(settingsId int, conn *dbus.Conn) (NetworkSetting, error) {
+	return GetSettingsContext(context.Background(), settingsId, conn)
+}
+
+// GetSettingsContext is GetSettings with a context which bounds the dbus
+// call.
+func GetSettingsContext(ctx context.Context, settingsId int, conn *dbus.Conn) (NetworkSetting, error) {
+	obj := conn.Object("org.freedesktop.NetworkManager", NetworkSetting{DbusId: settingsId}.DbusPath())
+
+	settings := obj.CallWithContext(ctx, "org.freedesktop.NetworkManager.Settings.Connection.GetSettings", 0)
 	if e := settings.Err; nil != e {
-		return NetworkSetting{}, e
+		return NetworkSetting{DbusId: settingsId}, classifyDbusError(e)
 	}
 	networkSettings, err := NewNetworkSetting(settings.Body[0])
 	networkSettings.DbusId = settingsId
-	if nil != err {
-		return networkSettings, err
+	return networkSettings, err
+}
+
+// FetchSecrets retrieves the secret of a connection obtained through
+// GetSettings, from NetworkManager or from the user's keyring.
+func (ns *NetworkSetting) FetchSecrets(conn *dbus.Conn) error {
+	return ns.FetchSecretsContext(context.Background(), conn)
+}
+
+// FetchSecretsContext is FetchSecrets with a context which bounds the dbus
+// calls.
+func (ns *NetworkSetting) FetchSecretsContext(ctx context.Context, conn *dbus.Conn) error {
+	return ns.optionalSecret(ns.fetchSecrets(ctx, conn))
+}
+
+func (ns *NetworkSetting) fetchSecrets(ctx context.Context, conn *dbus.Conn) error {
+	if !ns.NeedsSecret() {
+		return nil
+	}
+	if err := ns.checkSecretFlags(); nil != err {
+		return err
+	}
+	settingName, _, _ := ns.secret()
+	obj := conn.Object("org.freedesktop.NetworkManager", ns.DbusPath())
+	secrets := obj.CallWithContext(ctx, "org.freedesktop.NetworkManager.Settings.Connection.GetSecrets", 0, settingName)
+	if e := secrets.Err; nil != e {
+		return ns.secretsError(e)
+	}
+	if nil == ns.AddNetworkSecrets(secrets.Body[0]) {
+		ns.KeySource = SecretSourceNetworkManager
+		return nil
 	}
+	// agent-owned secrets are not handed out by NetworkManager,
+	// they may be in the user's keyring though.
+	return ns.addSecretServiceSecrets(ctx)
+}
 
-	if networkSettings.IsPsk {
-		secrets := obj.Call("org.freedesktop.NetworkManager.Settings.Connection.GetSecrets", 0, "802-11-wireless-security")
-		if e := secrets.Err; nil != e {
-			return NetworkSetting{}, fmt.Errorf("ERROR: %v", e)
-		}
-		networkSettings.AddNetworkSecrets(secrets.Body[0])
+// NeedsSecret tells if a QR code or profile of the connection contains a
+// secret, which FetchSecrets stores in Key.
+func (ns NetworkSetting) NeedsSecret() bool {
+	return ns.IsPsk || ns.IsWireGuard() || ns.IsEap()
+}
+
+// secret returns where the secret of the connection is stored.
+func (ns NetworkSetting) secret() (settingName, settingKey string, flags SecretFlags) {
+	if ns.IsWireGuard() {
+		return "wireguard", "private-key", ns.WireGuard.PrivateKeyFlags
 	}
-	return networkSettings, nil
+	if ns.IsEap() {
+		return "802-1x", "password", ns.Ieee8021x.PasswordFlags
+	}
+	if ns.IsWep() {
+		return "802-11-wireless-security", fmt.Sprintf("wep-key%d", ns.Security.WepTxKeyidx), ns.Security.WepKeyFlags
+	}
+	return "802-11-wireless-security", "psk", ns.PskFlags
+}
+
+// optionalSecret drops ErrNoSecret for 802.1x connections: certificate based
+// methods have no password, and without one the device asks the user.
+func (ns *NetworkSetting) optionalSecret(err error) error {
+	if ns.IsEap() && errors.Is(err, ErrNoSecret) {
+		return nil
+	}
+	return err
+}
+
+func (ns *NetworkSetting) checkSecretFlags() error {
+	_, key, flags := ns.secret()
+	if flags&SecretFlagNotSaved != 0 {
+		return fmt.Errorf("%w: the secret of %s is not saved (%s-flags=%d)", ErrNoSecret, ns.Id, key, flags)
+	}
+	return nil
+}
+
+// secretsError handles an error reply to GetSecrets.
+func (ns *NetworkSetting) secretsError(e error) error {
+	err := classifyDbusError(e)
+	if errors.Is(err, ErrPermissionDenied) {
+		ns.PermissionDenied = true
+	}
+	return fmt.Errorf("reading secret of %s: %w", ns.Id, err)
+}
+
+func (ns *NetworkSetting) addSecretServiceSecrets(ctx context.Context) error {
+	session, err := secretServiceBus()
+	if nil != err {
+		return fmt.Errorf("%w: not provided by NetworkManager and no session bus for the secret service: %v", ErrNoSecret, err)
+	}
+	settingName, settingKey, _ := ns.secret()
+	key, err := SecretServiceKey(ctx, session, ns.Uuid, settingName, settingKey)
+	if nil != err {
+		return fmt.Errorf("%w: not provided by NetworkManager, secret service: %v", ErrNoSecret, err)
+	}
+	ns.Key = key
+	ns.KeySource = SecretSourceSecretService
+	return nil
 }
@@ -0,0 +1,179 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/godbus/dbus"
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+)
+
+func startFake(t *testing.T, connections map[int]*nmtest.Connection) *dbus.Conn {
+	t.Helper()
+	server, err := nmtest.Start(connections)
+	if errors.Is(err, nmtest.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// never consult the keyring of the user running the tests
+	bus := secretServiceBus
+	secretServiceBus = func() (*dbus.Conn, error) { return nil, errors.New("no session bus in tests") }
+	t.Cleanup(func() { secretServiceBus = bus })
+	return conn
+}
+
+func TestGetNetworkSettings(t *testing.T) {
+	conn := startFake(t, map[int]*nmtest.Connection{
+		7: nmtest.WifiPsk("home", "uuid-7", "HomeNet", "homesecret"),
+	})
+	ns, err := GetNetworkSettings(7, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Id != "home" || ns.Uuid != "uuid-7" || string(ns.Ssid) != "HomeNet" || ns.DbusId != 7 {
+		t.Errorf("unexpected settings %+v", ns)
+	}
+	if !ns.IsPsk || ns.Sec != "WPA" || ns.Key != "homesecret" || ns.KeySource != SecretSourceNetworkManager {
+		t.Errorf("unexpected security settings %+v", ns)
+	}
+	if code := NetworkCode(ns); code != `WIFI:T:WPA;P:"homesecret";S:"HomeNet";;` {
+		t.Errorf("unexpected code %s", code)
+	}
+
+	if _, err := GetNetworkSettings(8, conn); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found for unknown connection, got %v", err)
+	}
+}
+
+func TestGetNetworkSettingsNoSecret(t *testing.T) {
+	denied := nmtest.WifiPsk("denied", "uuid-1", "DeniedNet", "secret")
+	denied.SecretsError = nmtest.PermissionDenied()
+	agent := nmtest.WifiPsk("agent", "uuid-2", "AgentNet", "")
+	agent.Secrets = nil
+	conn := startFake(t, map[int]*nmtest.Connection{1: denied, 2: agent})
+
+	ns, err := GetNetworkSettings(1, conn)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+	if !ns.PermissionDenied || ns.Id != "denied" {
+		t.Errorf("settings of denied connection not kept: %+v", ns)
+	}
+
+	ns, err = GetNetworkSettings(2, conn)
+	if !errors.Is(err, ErrNoSecret) {
+		t.Errorf("expected no secret, got %v", err)
+	}
+	if ns.Id != "agent" || ns.KeySource != SecretSourceNone {
+		t.Errorf("unexpected settings %+v", ns)
+	}
+}
+
+func TestGetNetworkSettingsOpen(t *testing.T) {
+	conn := startFake(t, map[int]*nmtest.Connection{
+		5: nmtest.WifiOpen("cafe", "uuid-5", "Cafe"),
+	})
+	ns, err := GetNetworkSettings(5, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Sec != "nopass" || ns.IsPsk || ns.NeedsSecret() || ns.KeySource != SecretSourceNone {
+		t.Errorf("unexpected settings %+v", ns)
+	}
+	if code := NetworkCode(ns); code != `WIFI:S:"Cafe";;` {
+		t.Errorf("unexpected code %s", code)
+	}
+}
+
+func TestGetNetworkSettingsWireGuard(t *testing.T) {
+	conn := startFake(t, map[int]*nmtest.Connection{
+		3: nmtest.WireGuard("vpn", "uuid-3", "cHJpdmF0ZQ==", "cHVibGlj", "cHJlc2hhcmVk"),
+	})
+	ns, err := GetNetworkSettings(3, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ns.IsWireGuard() || ns.Key != "cHJpdmF0ZQ==" || ns.KeySource != SecretSourceNetworkManager {
+		t.Errorf("unexpected settings %+v", ns)
+	}
+	want := `[Interface]
+PrivateKey = cHJpdmF0ZQ==
+Address = 10.0.0.2/32
+DNS = 10.0.0.1
+
+[Peer]
+PublicKey = cHVibGlj
+PresharedKey = cHJlc2hhcmVk
+AllowedIPs = 0.0.0.0/0, ::/0
+Endpoint = vpn.example.com:51820
+PersistentKeepalive = 25
+`
+	if config := Payload(ns); config != want {
+		t.Errorf("unexpected config\n%s", config)
+	}
+}
+
+// settingOf decodes a fake connection the way GetNetworkSettings does,
+// without a bus.
+func settingOf(t *testing.T, c *nmtest.Connection) NetworkSetting {
+	t.Helper()
+	ns, err := NewNetworkSetting(c.Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Secrets != nil {
+		if err := ns.AddNetworkSecrets(c.Secrets); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ns
+}
+
+func TestNewNetworkSettingWep(t *testing.T) {
+	ns := settingOf(t, nmtest.WifiWep("legacy", "uuid-4", "OldNet", "abcde", 2))
+	if ns.Sec != "WEP" || !ns.IsPsk || !ns.IsWep() || ns.Key != "abcde" || ns.Security.WepTxKeyidx != 2 {
+		t.Errorf("unexpected settings %+v", ns)
+	}
+	if _, key, _ := ns.secret(); key != "wep-key2" {
+		t.Errorf("secret read from %s", key)
+	}
+	if code := NetworkCode(ns); code != `WIFI:T:WEP;P:"abcde";S:"OldNet";;` {
+		t.Errorf("unexpected code %s", code)
+	}
+
+	missing := nmtest.WifiWep("legacy", "uuid-4", "OldNet", "abcde", 2)
+	ns = settingOf(t, &nmtest.Connection{Settings: missing.Settings})
+	err := ns.AddNetworkSecrets(map[string]map[string]dbus.Variant{
+		"802-11-wireless-security": {"wep-key0": dbus.MakeVariant("other")},
+	})
+	if !errors.Is(err, ErrNoSecret) {
+		t.Errorf("expected no secret for another key index, got %v", err)
+	}
+}
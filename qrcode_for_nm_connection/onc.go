@@ -0,0 +1,320 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// onc is an Open Network Configuration file as used by ChromeOS.
+type onc struct {
+	Type                  string           `json:"Type"`
+	NetworkConfigurations []oncNetwork     `json:"NetworkConfigurations"`
+	Certificates          []oncCertificate `json:"Certificates,omitempty"`
+}
+
+type oncNetwork struct {
+	GUID string   `json:"GUID"`
+	Name string   `json:"Name"`
+	Type string   `json:"Type"`
+	WiFi *oncWiFi `json:"WiFi,omitempty"`
+}
+
+type oncWiFi struct {
+	SSID        string  `json:"SSID,omitempty"`
+	HexSSID     string  `json:"HexSSID,omitempty"`
+	HiddenSSID  bool    `json:"HiddenSSID"`
+	AutoConnect *bool   `json:"AutoConnect,omitempty"`
+	Security    string  `json:"Security"`
+	Passphrase  string  `json:"Passphrase,omitempty"`
+	EAP         *oncEAP `json:"EAP,omitempty"`
+}
+
+type oncEAP struct {
+	Outer             string   `json:"Outer"`
+	Inner             string   `json:"Inner,omitempty"`
+	Identity          string   `json:"Identity,omitempty"`
+	AnonymousIdentity string   `json:"AnonymousIdentity,omitempty"`
+	Password          string   `json:"Password,omitempty"`
+	ServerCARefs      []string `json:"ServerCARefs,omitempty"`
+	UseSystemCAs      *bool    `json:"UseSystemCAs,omitempty"`
+	DomainSuffixMatch []string `json:"DomainSuffixMatch,omitempty"`
+	SaveCredentials   bool     `json:"SaveCredentials,omitempty"`
+}
+
+type oncCertificate struct {
+	GUID string `json:"GUID"`
+	Type string `json:"Type"` // Authority, Client or Server
+	X509 string `json:"X509,omitempty"`
+}
+
+// ONC outer EAP types for NetworkManager's eap methods
+var oncOuter = map[string]string{
+	"peap": "PEAP",
+	"ttls": "EAP-TTLS",
+	"tls":  "EAP-TLS",
+	"leap": "LEAP",
+	"sim":  "EAP-SIM",
+	"aka":  "EAP-AKA",
+}
+
+// ONC inner authentication for NetworkManager's phase2-auth
+var oncInner = map[string]string{
+	"pap":      "PAP",
+	"chap":     "CHAP",
+	"mschap":   "MSCHAP",
+	"mschapv2": "MSCHAPv2",
+	"gtc":      "GTC",
+	"md5":      "MD5",
+}
+
+// ONC network types for NetworkManager's connection types, other than WiFi
+var oncTypes = map[string]string{
+	"Ethernet": "802-3-ethernet",
+	"VPN":      "vpn",
+	"Cellular": "gsm",
+	"Tether":   "bluetooth",
+}
+
+func oncSecurity(ns NetworkSetting) (string, error) {
+	switch {
+	case ns.Security.KeyMgmt == "ieee8021x":
+		return "WEP-8021X", nil
+	case ns.IsEap():
+		return "WPA-EAP", nil
+	case ns.IsWep():
+		return "WEP-PSK", nil
+	case ns.Security.KeyMgmt == "wpa-psk" || ns.Security.KeyMgmt == "sae":
+		return "WPA-PSK", nil
+	case ns.Security.KeyMgmt == "":
+		return "None", nil
+	}
+	return "", fmt.Errorf("%w: ONC has no security for key-mgmt %s (%s)", ErrUnsupportedType, ns.Security.KeyMgmt, ns.Id)
+}
+
+// ONC renders a Wi-Fi connection as unencrypted Open Network Configuration,
+// which ChromeOS imports at chrome://network.
+func ONC(ns NetworkSetting) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if ns.IsWireGuard() {
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	guid := ns.Uuid
+	if guid == "" {
+		guid = strings.ToLower(nameUUID("onc:" + string(ns.Ssid)))
+	}
+	security, err := oncSecurity(ns)
+	if nil != err {
+		return nil, err
+	}
+	autoconnect := ns.Connection.Autoconnect
+	wifi := &oncWiFi{
+		SSID:        string(ns.Ssid),
+		HexSSID:     strings.ToUpper(hex.EncodeToString(ns.Ssid)),
+		HiddenSSID:  ns.IsHidden,
+		AutoConnect: &autoconnect,
+		Security:    security,
+	}
+	config := onc{Type: "UnencryptedConfiguration"}
+	if ns.IsEap() {
+		eap, ca, err := oncEapConfiguration(ns, guid)
+		if nil != err {
+			return nil, err
+		}
+		wifi.EAP = eap
+		if nil != ca {
+			config.Certificates = append(config.Certificates, *ca)
+		}
+	} else if ns.IsPsk {
+		wifi.Passphrase = ns.Key
+	}
+	config.NetworkConfigurations = []oncNetwork{{GUID: guid, Name: ns.Id, Type: "WiFi", WiFi: wifi}}
+	out, err := json.MarshalIndent(config, "", "  ")
+	if nil != err {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func oncEapConfiguration(ns NetworkSetting, guid string) (*oncEAP, *oncCertificate, error) {
+	s := ns.Ieee8021x
+	if len(s.Eap) == 0 {
+		return nil, nil, fmt.Errorf("no EAP method for %s", ns.Id)
+	}
+	outer, found := oncOuter[s.Eap[0]]
+	if !found {
+		return nil, nil, fmt.Errorf("EAP method %s of %s is not supported by ONC", s.Eap[0], ns.Id)
+	}
+	eap := &oncEAP{
+		Outer:             outer,
+		Inner:             oncInner[s.Phase2Auth],
+		Identity:          s.Identity,
+		AnonymousIdentity: s.AnonymousIdentity,
+		Password:          ns.Key,
+		SaveCredentials:   ns.Key != "",
+	}
+	if s.Phase2Auth == "" && s.Phase2Autheap != "" {
+		eap.Inner = oncInner[s.Phase2Autheap]
+	}
+	for _, domain := range strings.Split(s.DomainSuffixMatch, ";") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			eap.DomainSuffixMatch = append(eap.DomainSuffixMatch, domain)
+		}
+	}
+	cert, err := ns.CaCertificate()
+	if nil != err {
+		return nil, nil, err
+	}
+	if nil == cert {
+		useSystemCAs := true
+		eap.UseSystemCAs = &useSystemCAs
+		return eap, nil, nil
+	}
+	ca := &oncCertificate{GUID: guid + "-ca", Type: "Authority", X509: base64.StdEncoding.EncodeToString(cert)}
+	eap.ServerCARefs = []string{ca.GUID}
+	return eap, ca, nil
+}
+
+// ParseONC reads the network configurations of an unencrypted ONC file.
+// Networks of other types than WiFi are returned with only their name and
+// type, so that they are reported as unsupported.
+func ParseONC(r io.Reader) ([]NetworkSetting, error) {
+	var config onc
+	if err := json.NewDecoder(r).Decode(&config); nil != err {
+		return nil, fmt.Errorf("reading ONC: %v", err)
+	}
+	if config.Type != "UnencryptedConfiguration" {
+		return nil, fmt.Errorf("reading ONC: %q is not supported, export without a password", config.Type)
+	}
+	certificates := make(map[string][]byte)
+	for _, c := range config.Certificates {
+		der, err := base64.StdEncoding.DecodeString(c.X509)
+		if nil != err {
+			return nil, fmt.Errorf("reading ONC: certificate %s: %v", c.GUID, err)
+		}
+		certificates[c.GUID] = der
+	}
+	var retval []NetworkSetting
+	for _, network := range config.NetworkConfigurations {
+		var ns NetworkSetting
+		ns.Id = network.Name
+		ns.Uuid = strings.ToLower(strings.Trim(network.GUID, "{}"))
+		ns.Connection = ConnectionSettings{Id: ns.Id, Uuid: ns.Uuid, Type: oncTypes[network.Type], Autoconnect: true}
+		if network.Type != "WiFi" {
+			if ns.Connection.Type == "" {
+				ns.Connection.Type = strings.ToLower(network.Type)
+			}
+			retval = append(retval, ns)
+			continue
+		}
+		if nil == network.WiFi {
+			return nil, fmt.Errorf("reading ONC: network %s has no WiFi settings", ns.Id)
+		}
+		if err := ns.addONCWiFi(*network.WiFi, certificates); nil != err {
+			return nil, fmt.Errorf("reading ONC: network %s: %v", ns.Id, err)
+		}
+		retval = append(retval, ns)
+	}
+	return retval, nil
+}
+
+func (ns *NetworkSetting) addONCWiFi(wifi oncWiFi, certificates map[string][]byte) error {
+	ns.Ssid = []byte(wifi.SSID)
+	if wifi.HexSSID != "" {
+		ssid, err := hex.DecodeString(wifi.HexSSID)
+		if nil != err {
+			return fmt.Errorf("invalid HexSSID %q", wifi.HexSSID)
+		}
+		ns.Ssid = ssid
+	}
+	if len(ns.Ssid) == 0 {
+		return fmt.Errorf("no SSID")
+	}
+	if ns.Id == "" {
+		ns.Id = string(ns.Ssid)
+		ns.Connection.Id = ns.Id
+	}
+	ns.Connection.Type = "802-11-wireless"
+	if nil != wifi.AutoConnect {
+		ns.Connection.Autoconnect = *wifi.AutoConnect
+	}
+	ns.IsHidden = wifi.HiddenSSID
+	ns.Wireless = WirelessSettings{Ssid: ns.Ssid, Mode: "infrastructure", Hidden: ns.IsHidden}
+
+	switch wifi.Security {
+	case "None":
+	case "WEP-PSK":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WEP", true, "none"
+		ns.Key = wifi.Passphrase
+	case "WPA-PSK":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "wpa-psk"
+		ns.Key = wifi.Passphrase
+	case "WPA-EAP", "WEP-8021X":
+		ns.Sec, ns.Security.KeyMgmt = "WPA", "wpa-eap"
+		if wifi.Security == "WEP-8021X" {
+			ns.Sec, ns.Security.KeyMgmt = "WEP", "ieee8021x"
+		}
+		if nil == wifi.EAP {
+			return fmt.Errorf("%s without EAP settings", wifi.Security)
+		}
+		if err := ns.addONCEap(*wifi.EAP, certificates); nil != err {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown security %q", wifi.Security)
+	}
+	if ns.Key != "" {
+		ns.KeySource = SecretSourceFile
+	}
+	return nil
+}
+
+func (ns *NetworkSetting) addONCEap(eap oncEAP, certificates map[string][]byte) error {
+	s := &ns.Ieee8021x
+	for method, outer := range oncOuter {
+		if outer == eap.Outer {
+			s.Eap = []string{method}
+		}
+	}
+	if len(s.Eap) == 0 {
+		return fmt.Errorf("unsupported EAP method %q", eap.Outer)
+	}
+	for auth, inner := range oncInner {
+		if inner == eap.Inner {
+			s.Phase2Auth = auth
+		}
+	}
+	s.Identity = eap.Identity
+	s.AnonymousIdentity = eap.AnonymousIdentity
+	s.DomainSuffixMatch = strings.Join(eap.DomainSuffixMatch, ";")
+	for _, ref := range eap.ServerCARefs {
+		if cert, found := certificates[ref]; found {
+			s.CaCert = cert
+		}
+	}
+	ns.Key = eap.Password
+	return nil
+}
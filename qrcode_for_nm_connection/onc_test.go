@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+)
+
+func TestONCPsk(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Uuid = "0b5a4f3c-6c47-4b8c-9a4f-0e4c3f1d2a10"
+	ns.Key = `pass"word`
+	config, err := ONC(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"HexSSID": "4E6574"`, `"HiddenSSID": true`, `"Security": "WPA-PSK"`} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("configuration lacks %s:\n%s", want, config)
+		}
+	}
+	parsed, err := ParseONC(bytes.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("expected one network, got %d", len(parsed))
+	}
+	p := parsed[0]
+	if p.Id != ns.Id || p.Uuid != ns.Uuid || p.Key != ns.Key || NetworkCode(p) != NetworkCode(ns) {
+		t.Errorf("round trip changed the connection to %+v", p)
+	}
+}
+
+func TestONCWep(t *testing.T) {
+	ns := settingOf(t, nmtest.WifiWep("legacy", "uuid-4", "OldNet", "abcde", 0))
+	config, err := ONC(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Security": "WEP-PSK"`, `"Passphrase": "abcde"`} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("configuration lacks %s:\n%s", want, config)
+		}
+	}
+	parsed, err := ParseONC(bytes.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || NetworkCode(parsed[0]) != NetworkCode(ns) {
+		t.Errorf("round trip changed the connection to %+v", parsed)
+	}
+
+	ns.Security.KeyMgmt = "owe"
+	if _, err := ONC(ns); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected a key-mgmt without ONC security to be unsupported, got %v", err)
+	}
+}
+
+func TestONCEap(t *testing.T) {
+	ns := NetworkSetting{
+		Id:       "corp",
+		Uuid:     "5d0c1a52-3f5e-4c8e-8d8b-4a3b6c0f9e21",
+		Ssid:     []byte("CorpNet"),
+		Sec:      "WPA",
+		Key:      "hunter2",
+		Security: SecuritySettings{KeyMgmt: "wpa-eap"},
+		Ieee8021x: Ieee8021xSettings{
+			Eap:               []string{"ttls"},
+			Identity:          "alice",
+			AnonymousIdentity: "anonymous",
+			Phase2Auth:        "pap",
+			CaCert:            []byte{0x30, 0x03, 0x02, 0x01, 0x01},
+			DomainSuffixMatch: "radius.example.com",
+		},
+	}
+	ns.Connection.Autoconnect = true
+	config, err := ONC(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Outer": "EAP-TTLS"`, `"Inner": "PAP"`, `"Type": "Authority"`} {
+		if !strings.Contains(string(config), want) {
+			t.Errorf("configuration lacks %s:\n%s", want, config)
+		}
+	}
+	parsed, err := ParseONC(bytes.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("expected one network, got %d", len(parsed))
+	}
+	p := parsed[0]
+	if !p.IsEap() || p.IsPsk || p.Key != "hunter2" {
+		t.Errorf("unexpected security %+v", p)
+	}
+	if !reflect.DeepEqual(p.Ieee8021x, ns.Ieee8021x) {
+		t.Errorf("round trip changed the 802.1x settings to %+v", p.Ieee8021x)
+	}
+
+	ns.Ieee8021x.Eap = []string{"pwd"}
+	if _, err := ONC(ns); err == nil {
+		t.Errorf("expected an error for an EAP method ONC does not support")
+	}
+}
+
+func TestParseONCOtherTypes(t *testing.T) {
+	const config = `{
+  "Type": "UnencryptedConfiguration",
+  "NetworkConfigurations": [
+    {"GUID": "{A1B2}", "Name": "wired", "Type": "Ethernet", "Ethernet": {"Authentication": "None"}},
+    {"GUID": "guest", "Name": "Guest", "Type": "WiFi", "WiFi": {"SSID": "Guest", "Security": "None"}}
+  ]
+}`
+	parsed, err := ParseONC(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 2 {
+		t.Fatalf("expected two networks, got %d", len(parsed))
+	}
+	if err := parsed[0].CheckType(); !errors.Is(err, ErrUnsupportedType) || parsed[0].Uuid != "a1b2" {
+		t.Errorf("expected the ethernet network to be unsupported, got %v for %+v", err, parsed[0])
+	}
+	if g := parsed[1]; string(g.Ssid) != "Guest" || g.IsPsk || g.IsEap() || g.Key != "" {
+		t.Errorf("unexpected open network %+v", g)
+	}
+
+	if _, err := ParseONC(strings.NewReader(`{"Type": "EncryptedConfiguration"}`)); err == nil {
+		t.Errorf("expected encrypted configurations to be rejected")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// uciSection is a "config" section of an OpenWrt UCI file.
+type uciSection struct {
+	typ, name string
+	options   map[string]string
+}
+
+// uciWords splits a line of a UCI file into words like a shell: quotes
+// group, "" and bare words take backslash escapes, and # starts a
+// comment.
+func uciWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '#' && !inWord:
+			return words, nil
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated '")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("unterminated \"")
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// parseUCI reads the sections of a UCI file, lists are not kept.
+func parseUCI(r io.Reader) ([]*uciSection, error) {
+	var sections []*uciSection
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		words, err := uciWords(scanner.Text())
+		if nil != err {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "package":
+		case "config":
+			if len(words) < 2 || len(words) > 3 {
+				return nil, fmt.Errorf("line %d: expected config TYPE [NAME]", line)
+			}
+			s := &uciSection{typ: words[1], options: map[string]string{}}
+			if len(words) == 3 {
+				s.name = words[2]
+			}
+			sections = append(sections, s)
+		case "option", "list":
+			if len(words) != 3 {
+				return nil, fmt.Errorf("line %d: expected %s NAME VALUE", line, words[0])
+			}
+			if len(sections) == 0 {
+				return nil, fmt.Errorf("line %d: %s outside of a config section", line, words[0])
+			}
+			if words[0] == "option" {
+				sections[len(sections)-1].options[words[1]] = words[2]
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown keyword %s", line, words[0])
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// ParseOpenWrtWireless reads the networks of the wifi-iface sections of an
+// OpenWrt /etc/config/wireless, both those the router provides and those
+// it joins. A network is named by its section, or by its SSID if the
+// section has no name. Disabled networks (or networks of a disabled radio)
+// are not connected automatically. Mesh and monitor interfaces are left
+// out, networks with an unsupported encryption are marked Unsupported.
+func ParseOpenWrtWireless(r io.Reader) ([]NetworkSetting, error) {
+	sections, err := parseUCI(r)
+	if nil != err {
+		return nil, fmt.Errorf("reading UCI: %v", err)
+	}
+	devices := make(map[string]*uciSection)
+	for _, s := range sections {
+		if s.typ == "wifi-device" && s.name != "" {
+			devices[s.name] = s
+		}
+	}
+	var retval []NetworkSetting
+	for i, s := range sections {
+		if s.typ != "wifi-iface" {
+			continue
+		}
+		switch s.options["mode"] {
+		case "mesh", "monitor":
+			continue
+		}
+		device := devices[s.options["device"]]
+		if nil == device {
+			device = &uciSection{options: map[string]string{}}
+		}
+		ns, err := openWrtSetting(s, device)
+		if nil != err {
+			name := s.name
+			if name == "" {
+				name = fmt.Sprintf("section %d", i+1)
+			}
+			return nil, fmt.Errorf("reading UCI: %s: %v", name, err)
+		}
+		retval = append(retval, ns)
+	}
+	return retval, nil
+}
+
+func openWrtSetting(s, device *uciSection) (NetworkSetting, error) {
+	var ns NetworkSetting
+	o := s.options
+	ns.Ssid = []byte(o["ssid"])
+	if len(ns.Ssid) == 0 {
+		return ns, fmt.Errorf("no ssid")
+	}
+	ns.Id = s.name
+	if ns.Id == "" {
+		ns.Id = o["ssid"]
+	}
+	ns.IsHidden = o["hidden"] == "1"
+	disabled := o["disabled"] == "1" || device.options["disabled"] == "1"
+	ns.Connection = ConnectionSettings{Id: ns.Id, Type: "802-11-wireless", InterfaceName: o["ifname"], Autoconnect: !disabled}
+	ns.Wireless = WirelessSettings{Ssid: ns.Ssid, Mode: "infrastructure", Hidden: ns.IsHidden}
+	if o["mode"] == "adhoc" {
+		ns.Wireless.Mode = "adhoc"
+	}
+	switch band := device.options["band"]; {
+	case band == "2g", device.options["hwmode"] == "11g", device.options["hwmode"] == "11b":
+		ns.Wireless.Band = "bg"
+	case band == "5g", device.options["hwmode"] == "11a":
+		ns.Wireless.Band = "a"
+	}
+	if channel, err := strconv.ParseUint(device.options["channel"], 10, 32); nil == err {
+		ns.Wireless.Channel = uint32(channel)
+	}
+	if bssid, found := o["bssid"]; found {
+		mac, err := net.ParseMAC(bssid)
+		if nil != err {
+			return ns, fmt.Errorf("bssid: %v", err)
+		}
+		ns.Wireless.Bssid = mac
+	}
+	switch o["ieee80211w"] {
+	case "1":
+		ns.Security.Pmf = PmfOptional
+	case "2":
+		ns.Security.Pmf = PmfRequired
+	}
+
+	// ciphers follow the type, e.g. psk2+ccmp
+	encryption, _, _ := strings.Cut(o["encryption"], "+")
+	switch encryption {
+	case "", "none":
+	case "owe":
+		ns.Security.KeyMgmt = "owe"
+	case "psk", "psk2", "psk-mixed", "sae-mixed":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "wpa-psk"
+		ns.Key = o["key"]
+	case "sae":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "sae"
+		ns.Key = o["key"]
+	case "wpa", "wpa2", "wpa-mixed", "wpa3", "wpa3-mixed", "wpa3-192":
+		ns.Sec, ns.Security.KeyMgmt = "WPA", "wpa-eap"
+		if encryption == "wpa3-192" {
+			ns.Security.KeyMgmt = "wpa-eap-suite-b-192"
+		}
+		ns.Ieee8021x = Ieee8021xSettings{
+			Identity:          o["identity"],
+			AnonymousIdentity: o["anonymous_identity"],
+			Phase2Auth:        strings.ToLower(strings.TrimPrefix(o["auth"], "EAP-")),
+		}
+		if eap := o["eap_type"]; eap != "" {
+			ns.Ieee8021x.Eap = []string{eap}
+		}
+		if ca := o["ca_cert"]; ca != "" {
+			ns.Ieee8021x.CaCert = []byte("file://" + ca + "\x00")
+		}
+		ns.Key = o["password"]
+	case "wep", "wep-open", "wep-shared":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WEP", true, "none"
+		ns.Security.AuthAlg = "open"
+		if encryption == "wep-shared" {
+			ns.Security.AuthAlg = "shared"
+		}
+		// the key is the number of the keyN option to use or the key
+		key := o["key"]
+		if index, err := strconv.Atoi(key); nil == err && index >= 1 && index <= 4 {
+			ns.Security.WepTxKeyidx = uint32(index - 1)
+			key = o["key"+key]
+		}
+		// ASCII keys are marked with s:, hex keys are given as they are
+		ns.Key = strings.TrimPrefix(key, "s:")
+	default:
+		// listed as unsupported, the other networks can still be used
+		ns.Unsupported = "encryption " + o["encryption"]
+	}
+	if ns.Key != "" {
+		ns.KeySource = SecretSourceFile
+	}
+	return ns, nil
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const openWrtWireless = `
+config wifi-device 'radio0'
+	option type 'mac80211'
+	option path 'platform/18000000.wifi'
+	option band '2g'
+	option channel '6'
+
+config wifi-device 'radio1'
+	option type 'mac80211'
+	option hwmode '11a'
+	option channel 'auto'
+	option disabled '1'
+
+config wifi-iface 'default_radio0'
+	option device 'radio0'
+	option network 'lan'
+	option mode 'ap'
+	option ssid "Branch \"North\""
+	option encryption 'psk2+ccmp'
+	option key 'it'\''s secret'
+
+config wifi-iface 'guest'
+	option device 'radio0'
+	option network 'guest'
+	option mode 'ap'
+	option ssid 'Guests'
+	option encryption 'sae-mixed'
+	option key 'welcome1'
+	option hidden '1'
+	list maclist '00:11:22:33:44:55' # comment
+
+config wifi-iface
+	option device 'radio1'
+	option mode 'ap'
+	option ssid 'Lobby'
+	option encryption 'wep'
+	option key '2'
+	option key2 's:abcde'
+
+config wifi-iface 'ciphers'
+	option device 'radio0'
+	option mode 'ap'
+	option ssid 'Staff'
+	option encryption 'ccmp'
+
+config wifi-iface 'mesh'
+	option device 'radio1'
+	option mode 'mesh'
+	option mesh_id 'backhaul'
+`
+
+func TestParseOpenWrtWireless(t *testing.T) {
+	parsed, err := ParseOpenWrtWireless(strings.NewReader(openWrtWireless))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 4 {
+		t.Fatalf("expected four networks, got %d", len(parsed))
+	}
+	branch, guest, lobby, staff := parsed[0], parsed[1], parsed[2], parsed[3]
+	if branch.Id != "default_radio0" || string(branch.Ssid) != `Branch "North"` || branch.Key != "it's secret" || branch.Security.KeyMgmt != "wpa-psk" || branch.Wireless.Band != "bg" || branch.Wireless.Channel != 6 {
+		t.Errorf("unexpected network %+v", branch)
+	}
+	if guest.Id != "guest" || !guest.IsHidden || !guest.IsPsk || guest.Key != "welcome1" || !guest.Connection.Autoconnect {
+		t.Errorf("unexpected network %+v", guest)
+	}
+	if lobby.Id != "Lobby" || lobby.Sec != "WEP" || lobby.Key != "abcde" || lobby.Security.WepTxKeyidx != 1 || lobby.Connection.Autoconnect || lobby.Wireless.Band != "a" {
+		t.Errorf("unexpected network %+v", lobby)
+	}
+
+	for _, ns := range parsed[:3] {
+		if err := ns.CheckType(); err != nil {
+			t.Errorf("%s: %v", ns.Id, err)
+		}
+	}
+	if err := staff.CheckType(); !errors.Is(err, ErrUnsupportedType) || staff.Id != "ciphers" {
+		t.Errorf("expected %s to be unsupported, got %v", staff.Id, err)
+	}
+
+	for _, conf := range []string{"option ssid 'x'\n", "config wifi-iface\n\toption ssid 'x\n"} {
+		if _, err := ParseOpenWrtWireless(strings.NewReader(conf)); err == nil {
+			t.Errorf("expected an error for %q", conf)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// plistDict is a property list dictionary which keeps the order of its
+// keys when written.
+type plistDict []plistEntry
+
+type plistEntry struct {
+	Key   string
+	Value interface{}
+}
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// writePlist writes v as XML property list. Supported values are
+// plistDict, []interface{}, []string, string, bool, int, uint32 and []byte.
+func writePlist(w io.Writer, v interface{}) error {
+	var b strings.Builder
+	b.WriteString(plistHeader)
+	if err := writePlistValue(&b, v, 0); nil != err {
+		return err
+	}
+	b.WriteString("</plist>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writePlistValue(b *strings.Builder, v interface{}, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	escape := func(s string) string {
+		var e strings.Builder
+		xml.EscapeText(&e, []byte(s))
+		return e.String()
+	}
+	switch v := v.(type) {
+	case plistDict:
+		b.WriteString(indent + "<dict>\n")
+		for _, entry := range v {
+			fmt.Fprintf(b, "%s\t<key>%s</key>\n", indent, escape(entry.Key))
+			if err := writePlistValue(b, entry.Value, depth+1); nil != err {
+				return err
+			}
+		}
+		b.WriteString(indent + "</dict>\n")
+	case []interface{}:
+		b.WriteString(indent + "<array>\n")
+		for _, e := range v {
+			if err := writePlistValue(b, e, depth+1); nil != err {
+				return err
+			}
+		}
+		b.WriteString(indent + "</array>\n")
+	case []string:
+		b.WriteString(indent + "<array>\n")
+		for _, e := range v {
+			fmt.Fprintf(b, "%s\t<string>%s</string>\n", indent, escape(e))
+		}
+		b.WriteString(indent + "</array>\n")
+	case string:
+		fmt.Fprintf(b, "%s<string>%s</string>\n", indent, escape(v))
+	case bool:
+		fmt.Fprintf(b, "%s<%t/>\n", indent, v)
+	case int:
+		fmt.Fprintf(b, "%s<integer>%d</integer>\n", indent, v)
+	case uint32:
+		fmt.Fprintf(b, "%s<integer>%d</integer>\n", indent, v)
+	case []byte:
+		fmt.Fprintf(b, "%s<data>%s</data>\n", indent, base64.StdEncoding.EncodeToString(v))
+	default:
+		return fmt.Errorf("cannot write %T to a property list", v)
+	}
+	return nil
+}
+
+// readPlist parses an XML property list. Dictionaries become
+// map[string]interface{}, arrays []interface{}, integers int64, reals
+// float64, data []byte and strings and dates string.
+func readPlist(r io.Reader) (interface{}, error) {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if nil != err {
+			return nil, fmt.Errorf("reading property list: %v", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Local != "plist" {
+				return nil, fmt.Errorf("expected a property list, got <%s>", start.Name.Local)
+			}
+			v, _, err := readPlistValue(d)
+			return v, err
+		}
+	}
+}
+
+// readPlistValue reads the next value, end is set when the enclosing
+// element ends instead.
+func readPlistValue(d *xml.Decoder) (v interface{}, end bool, err error) {
+	for {
+		tok, err := d.Token()
+		if nil != err {
+			return nil, false, fmt.Errorf("reading property list: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.EndElement:
+			return nil, true, nil
+		case xml.StartElement:
+			v, err := readPlistElement(d, tok)
+			return v, false, err
+		}
+	}
+}
+
+func readPlistElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		retval := make(map[string]interface{})
+		for {
+			key, end, err := readPlistValue(d)
+			if nil != err {
+				return nil, err
+			}
+			if end {
+				return retval, nil
+			}
+			k, ok := key.(plistKey)
+			if !ok {
+				return nil, fmt.Errorf("property list dictionary entry without key")
+			}
+			value, end, err := readPlistValue(d)
+			if nil != err {
+				return nil, err
+			}
+			if end {
+				return nil, fmt.Errorf("property list key %s without value", k)
+			}
+			retval[string(k)] = value
+		}
+	case "array":
+		retval := []interface{}{}
+		for {
+			value, end, err := readPlistValue(d)
+			if nil != err {
+				return nil, err
+			}
+			if end {
+				return retval, nil
+			}
+			retval = append(retval, value)
+		}
+	case "true", "false":
+		if err := d.Skip(); nil != err {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+	var text string
+	if err := d.DecodeElement(&text, &start); nil != err {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "key":
+		return plistKey(text), nil
+	case "string", "date":
+		return text, nil
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+	return nil, fmt.Errorf("unknown property list element <%s>", start.Name.Local)
+}
+
+// plistKey distinguishes dictionary keys from string values while reading.
+type plistKey string
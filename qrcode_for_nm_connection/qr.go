@@ -48,10 +48,22 @@ func NetworkCode(ns NetworkSetting) string {
 	return setupcode
 }
 
+// Payload is the content of the QR code for a connection: the WIFI: code
+// for Wi-Fi connections, a wg-quick configuration for WireGuard connections.
+func Payload(ns NetworkSetting) string {
+	if ns.IsWireGuard() {
+		return WireGuardConfig(ns)
+	}
+	return NetworkCode(ns)
+}
+
 func QRNetworkCode(ns NetworkSetting) (qrcode.QRCode, error) {
-	setupcode := NetworkCode(ns)
+	return QRCode(Payload(ns))
+}
 
-	code, err := qrcode.New(setupcode, qrcode.Medium)
+// QRCode encodes any payload, such as a DPP URI, as QR code.
+func QRCode(payload string) (qrcode.QRCode, error) {
+	code, err := qrcode.New(payload, qrcode.Medium)
 	if nil != err {
 		return qrcode.QRCode{}, err
 	}
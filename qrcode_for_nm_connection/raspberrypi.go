@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsCountryCode tells if code is an ISO 3166-1 alpha-2 country code as
+// needed for the regulatory domain, e.g. "DE".
+func IsCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// RaspberryPiBoot returns the files for the boot partition of a Raspberry
+// Pi OS image which connect it to the network on first boot, keyed by
+// file name:
+//
+//	network-config              cloud-init (images made for cloud-init)
+//	wpa_supplicant.conf         Raspberry Pi OS up to Bullseye
+//	preconfigured.nmconnection  NetworkManager keyfile for Bookworm
+//
+// Only open and WPA personal networks are supported, country is the
+// regulatory domain.
+func RaspberryPiBoot(ns NetworkSetting, country string) (map[string][]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if !IsCountryCode(country) {
+		return nil, fmt.Errorf("invalid country code %q, expected e.g. DE", country)
+	}
+	switch s := ns.Security.KeyMgmt; {
+	case ns.IsWireGuard():
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	case s == "wpa-psk" || s == "sae":
+		if ns.Key == "" {
+			return nil, fmt.Errorf("%w: the password of %s is needed on the Raspberry Pi", ErrNoSecret, ns.Id)
+		}
+	case s != "":
+		return nil, fmt.Errorf("%w: headless provisioning only supports open and WPA personal networks, %s uses %s", ErrUnsupportedType, ns.Id, s)
+	}
+
+	files := make(map[string][]byte)
+	var err error
+	if files["network-config"], err = netplanConfig(ns, "wlan0", country); nil != err {
+		return nil, err
+	}
+	block, err := WpaSupplicant(ns)
+	if nil != err {
+		return nil, err
+	}
+	var conf strings.Builder
+	conf.WriteString("ctrl_interface=DIR=/var/run/wpa_supplicant GROUP=netdev\n")
+	conf.WriteString("update_config=1\n")
+	fmt.Fprintf(&conf, "country=%s\n\n", country)
+	conf.Write(block)
+	files["wpa_supplicant.conf"] = []byte(conf.String())
+	if files["preconfigured.nmconnection"], err = Keyfile(ns); nil != err {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRaspberryPiBoot(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Key = "secret123"
+	if _, err := RaspberryPiBoot(ns, "de"); err == nil {
+		t.Errorf("expected an error for a lower case country code")
+	}
+	files, err := RaspberryPiBoot(ns, "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `network:
+  version: 2
+  wifis:
+    wlan0:
+      dhcp4: true
+      optional: true
+      regulatory-domain: DE
+      access-points:
+        Net:
+          password: secret123
+          hidden: true
+`
+	if got := string(files["network-config"]); got != want {
+		t.Errorf("unexpected network-config:\n%s", got)
+	}
+	for name, want := range map[string]string{
+		"wpa_supplicant.conf":        "update_config=1\ncountry=DE\n\nnetwork={\n\tssid=\"Net\"\n",
+		"preconfigured.nmconnection": "\npsk=secret123\n",
+	} {
+		if !strings.Contains(string(files[name]), want) {
+			t.Errorf("%s lacks %q:\n%s", name, want, files[name])
+		}
+	}
+
+	ns.Key = ""
+	if _, err := RaspberryPiBoot(ns, "DE"); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("expected the password to be required, got %v", err)
+	}
+	ns.Security.KeyMgmt = "wpa-eap"
+	if _, err := RaspberryPiBoot(ns, "DE"); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected 802.1x to be unsupported, got %v", err)
+	}
+}
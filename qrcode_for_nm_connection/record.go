@@ -0,0 +1,514 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// Fixture is a recording of what NetworkManager returned for some
+// connections. It is stored as JSON and can be replayed instead of talking to
+// NetworkManager, to reproduce bug reports.
+type Fixture struct {
+	Connections []RecordedConnection `json:"connections"`
+}
+
+// RecordedConnection holds the GetSettings and GetSecrets results of one
+// connection, or the errors NetworkManager returned instead.
+type RecordedConnection struct {
+	DbusId        int              `json:"dbus_id"`
+	Settings      RecordedSettings `json:"settings,omitempty"`
+	SettingsError *RecordedError   `json:"settings_error,omitempty"`
+	Secrets       RecordedSettings `json:"secrets,omitempty"`
+	SecretsError  *RecordedError   `json:"secrets_error,omitempty"`
+}
+
+// RecordedSettings is a settings map (a{sa{sv}}) with typed values.
+type RecordedSettings map[string]map[string]TypedValue
+
+// RecordedError is a dbus error reply.
+type RecordedError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// TypedValue is a dbus value together with its signature. Byte arrays are
+// written as hex strings, dictionaries as JSON objects, structs as JSON
+// arrays.
+type TypedValue struct {
+	Signature string          `json:"signature"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// redacted replaces string secrets in fixtures recorded without secrets.
+const redacted = "<redacted>"
+
+// secretSettings are the settings for which secrets are recorded.
+var secretSettings = []string{"802-11-wireless-security", "802-1x", "wireguard"}
+
+func newRecordedError(err error) *RecordedError {
+	retval := &RecordedError{Name: dbusErrorName(err), Message: err.Error()}
+	if retval.Name == "" {
+		retval.Name = "org.freedesktop.DBus.Error.Failed"
+	}
+	return retval
+}
+
+func (e *RecordedError) err() error {
+	return dbus.Error{Name: e.Name, Body: []interface{}{e.Message}}
+}
+
+// RecordConnection captures the raw answers of NetworkManager for a
+// connection. Unless withSecrets is set, string secrets are replaced by
+// "<redacted>" and other secrets dropped.
+func RecordConnection(ctx context.Context, settingsId int, conn *dbus.Conn, withSecrets bool) (RecordedConnection, error) {
+	retval := RecordedConnection{DbusId: settingsId}
+	obj := conn.Object("org.freedesktop.NetworkManager", NetworkSetting{DbusId: settingsId}.DbusPath())
+
+	settings := obj.CallWithContext(ctx, "org.freedesktop.NetworkManager.Settings.Connection.GetSettings", 0)
+	if e := settings.Err; nil != e {
+		retval.SettingsError = newRecordedError(e)
+		return retval, nil
+	}
+	settingsMap, ok := settings.Body[0].(map[string]map[string]dbus.Variant)
+	if !ok {
+		return retval, fmt.Errorf("unexpected GetSettings reply %v", settings.Body[0])
+	}
+	var err error
+	if retval.Settings, err = NewRecordedSettings(settingsMap); nil != err {
+		return retval, err
+	}
+
+	for _, name := range secretSettings {
+		if _, found := settingsMap[name]; !found {
+			continue
+		}
+		secrets := obj.CallWithContext(ctx, "org.freedesktop.NetworkManager.Settings.Connection.GetSecrets", 0, name)
+		if e := secrets.Err; nil != e {
+			retval.SecretsError = newRecordedError(e)
+			continue
+		}
+		secretsMap, ok := secrets.Body[0].(map[string]map[string]dbus.Variant)
+		if !ok {
+			return retval, fmt.Errorf("unexpected GetSecrets reply %v", secrets.Body[0])
+		}
+		if !withSecrets {
+			redact(secretsMap)
+		}
+		recorded, err := NewRecordedSettings(secretsMap)
+		if nil != err {
+			return retval, err
+		}
+		if nil == retval.Secrets {
+			retval.Secrets = make(RecordedSettings)
+		}
+		for k, v := range recorded {
+			retval.Secrets[k] = v
+		}
+	}
+	return retval, nil
+}
+
+func redact(secrets map[string]map[string]dbus.Variant) {
+	for _, block := range secrets {
+		for key, value := range block {
+			if _, isString := value.Value().(string); isString {
+				block[key] = dbus.MakeVariant(redacted)
+			} else {
+				delete(block, key)
+			}
+		}
+	}
+}
+
+// NetworkSetting replays the recorded GetSettings answer through
+// NewNetworkSetting.
+func (rc RecordedConnection) NetworkSetting() (NetworkSetting, error) {
+	if nil != rc.SettingsError {
+		return NetworkSetting{DbusId: rc.DbusId}, classifyDbusError(rc.SettingsError.err())
+	}
+	settings, err := rc.Settings.Map()
+	if nil != err {
+		return NetworkSetting{DbusId: rc.DbusId}, err
+	}
+	ns, err := NewNetworkSetting(settings)
+	ns.DbusId = rc.DbusId
+	return ns, err
+}
+
+// AddSecrets replays the recorded GetSecrets answer into ns, like
+// FetchSecrets does for a live connection.
+func (rc RecordedConnection) AddSecrets(ns *NetworkSetting) error {
+	return ns.optionalSecret(rc.addSecrets(ns))
+}
+
+func (rc RecordedConnection) addSecrets(ns *NetworkSetting) error {
+	if !ns.NeedsSecret() {
+		return nil
+	}
+	if err := ns.checkSecretFlags(); nil != err {
+		return err
+	}
+	if nil != rc.SecretsError {
+		return ns.secretsError(rc.SecretsError.err())
+	}
+	secrets, err := rc.Secrets.Map()
+	if nil != err {
+		return err
+	}
+	if err := ns.AddNetworkSecrets(secrets); nil != err {
+		return err
+	}
+	// a fixture recorded without secrets has only placeholders
+	for i := range ns.WireGuard.Peers {
+		if ns.WireGuard.Peers[i].PresharedKey == redacted {
+			ns.WireGuard.Peers[i].PresharedKey = ""
+		}
+	}
+	if ns.Key == redacted {
+		ns.Key = ""
+		return fmt.Errorf("%w: the secret of %s was redacted when recording", ErrNoSecret, ns.Id)
+	}
+	ns.KeySource = SecretSourceFile
+	return nil
+}
+
+// NewRecordedSettings converts a settings map as returned by dbus.
+func NewRecordedSettings(settings map[string]map[string]dbus.Variant) (RecordedSettings, error) {
+	retval := make(RecordedSettings)
+	for name, block := range settings {
+		retval[name] = make(map[string]TypedValue)
+		for key, value := range block {
+			tv, err := newTypedValue(value)
+			if nil != err {
+				return nil, fmt.Errorf("%s.%s: %v", name, key, err)
+			}
+			retval[name][key] = tv
+		}
+	}
+	return retval, nil
+}
+
+// Map converts recorded settings back to the map dbus would return.
+func (rs RecordedSettings) Map() (map[string]map[string]dbus.Variant, error) {
+	retval := make(map[string]map[string]dbus.Variant)
+	for name, block := range rs {
+		retval[name] = make(map[string]dbus.Variant)
+		for key, tv := range block {
+			v, err := tv.Variant()
+			if nil != err {
+				return nil, fmt.Errorf("%s.%s: %v", name, key, err)
+			}
+			retval[name][key] = v
+		}
+	}
+	return retval, nil
+}
+
+func newTypedValue(v dbus.Variant) (TypedValue, error) {
+	encoded, err := encodeValue(reflect.ValueOf(v.Value()))
+	if nil != err {
+		return TypedValue{}, err
+	}
+	raw, err := json.Marshal(encoded)
+	if nil != err {
+		return TypedValue{}, err
+	}
+	return TypedValue{Signature: v.Signature().String(), Value: raw}, nil
+}
+
+// Variant converts the typed value back to a dbus variant of the recorded
+// type.
+func (tv TypedValue) Variant() (dbus.Variant, error) {
+	sig, err := dbus.ParseSignature(tv.Signature)
+	if nil != err {
+		return dbus.Variant{}, err
+	}
+	// not sig.Single(), it reports the opposite in the godbus version we use
+	if _, rest, err := splitSignature(tv.Signature); err != nil || rest != "" {
+		return dbus.Variant{}, fmt.Errorf("signature %q is not a single type", tv.Signature)
+	}
+	value, err := decodeValue(tv.Signature, tv.Value)
+	if nil != err {
+		return dbus.Variant{}, err
+	}
+	return dbus.MakeVariantWithSignature(value, sig), nil
+}
+
+var (
+	variantType   = reflect.TypeOf(dbus.Variant{})
+	signatureType = reflect.TypeOf(dbus.Signature{})
+	bytesType     = reflect.TypeOf([]byte{})
+)
+
+func encodeValue(v reflect.Value) (interface{}, error) {
+	if v.Type() == variantType {
+		return newTypedValue(v.Interface().(dbus.Variant))
+	}
+	if v.Type() == bytesType {
+		return hex.EncodeToString(v.Bytes()), nil
+	}
+	if v.Type() == signatureType {
+		return v.Interface().(dbus.Signature).String(), nil
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		retval := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, err := encodeValue(v.Index(i))
+			if nil != err {
+				return nil, err
+			}
+			retval = append(retval, e)
+		}
+		return retval, nil
+	case reflect.Map:
+		retval := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			e, err := encodeValue(v.MapIndex(key))
+			if nil != err {
+				return nil, err
+			}
+			retval[fmt.Sprint(key.Interface())] = e
+		}
+		return retval, nil
+	case reflect.Interface:
+		return encodeValue(v.Elem())
+	}
+	return v.Interface(), nil
+}
+
+// splitSignature returns the first complete type of sig and the rest.
+func splitSignature(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", fmt.Errorf("empty signature")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, rest, err := splitSignature(sig[1:])
+		return "a" + elem, rest, err
+	case '(', '{':
+		closing := map[byte]byte{'(': ')', '{': '}'}[sig[0]]
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					if sig[i] != closing {
+						return "", "", fmt.Errorf("unbalanced signature %q", sig)
+					}
+					return sig[:i+1], sig[i+1:], nil
+				}
+			}
+		}
+		return "", "", fmt.Errorf("unbalanced signature %q", sig)
+	}
+	return sig[:1], sig[1:], nil
+}
+
+// fields splits the signature of a struct or dict entry into its members.
+func fields(sig string) ([]string, error) {
+	var retval []string
+	rest := sig[1 : len(sig)-1]
+	for rest != "" {
+		field, r, err := splitSignature(rest)
+		if nil != err {
+			return nil, err
+		}
+		retval = append(retval, field)
+		rest = r
+	}
+	return retval, nil
+}
+
+// goType is the type godbus decodes values of signature sig into.
+func goType(sig string) (reflect.Type, error) {
+	switch sig[0] {
+	case 'y':
+		return reflect.TypeOf(byte(0)), nil
+	case 'b':
+		return reflect.TypeOf(false), nil
+	case 'n':
+		return reflect.TypeOf(int16(0)), nil
+	case 'q':
+		return reflect.TypeOf(uint16(0)), nil
+	case 'i':
+		return reflect.TypeOf(int32(0)), nil
+	case 'u':
+		return reflect.TypeOf(uint32(0)), nil
+	case 'x':
+		return reflect.TypeOf(int64(0)), nil
+	case 't':
+		return reflect.TypeOf(uint64(0)), nil
+	case 'd':
+		return reflect.TypeOf(float64(0)), nil
+	case 's':
+		return reflect.TypeOf(""), nil
+	case 'o':
+		return reflect.TypeOf(dbus.ObjectPath("")), nil
+	case 'g':
+		return signatureType, nil
+	case 'v':
+		return variantType, nil
+	case '(':
+		return reflect.TypeOf([]interface{}{}), nil
+	case 'a':
+		if strings.HasPrefix(sig, "a{") {
+			kv, err := fields(sig[1:])
+			if nil != err {
+				return nil, err
+			}
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid dict signature %q", sig)
+			}
+			k, err := goType(kv[0])
+			if nil != err {
+				return nil, err
+			}
+			v, err := goType(kv[1])
+			if nil != err {
+				return nil, err
+			}
+			return reflect.MapOf(k, v), nil
+		}
+		elem, err := goType(sig[1:])
+		if nil != err {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	}
+	return nil, fmt.Errorf("unsupported signature %q", sig)
+}
+
+func decodeValue(sig string, raw json.RawMessage) (interface{}, error) {
+	switch {
+	case sig == "v":
+		var tv TypedValue
+		if err := json.Unmarshal(raw, &tv); nil != err {
+			return nil, err
+		}
+		return tv.Variant()
+	case sig == "g":
+		var s string
+		if err := json.Unmarshal(raw, &s); nil != err {
+			return nil, err
+		}
+		return dbus.ParseSignature(s)
+	case sig == "ay":
+		var s string
+		if err := json.Unmarshal(raw, &s); nil != err {
+			return nil, err
+		}
+		return hex.DecodeString(s)
+	case strings.HasPrefix(sig, "a{"):
+		kv, err := fields(sig[1:])
+		if nil != err {
+			return nil, err
+		}
+		t, err := goType(sig)
+		if nil != err {
+			return nil, err
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entries); nil != err {
+			return nil, err
+		}
+		retval := reflect.MakeMapWithSize(t, len(entries))
+		keys := make([]string, 0, len(entries))
+		for k := range entries {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			keyraw := json.RawMessage(k)
+			if kv[0] == "s" || kv[0] == "o" || kv[0] == "g" {
+				keyraw, _ = json.Marshal(k)
+			}
+			key, err := decodeValue(kv[0], keyraw)
+			if nil != err {
+				return nil, err
+			}
+			value, err := decodeValue(kv[1], entries[k])
+			if nil != err {
+				return nil, err
+			}
+			retval.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(value))
+		}
+		return retval.Interface(), nil
+	case sig[0] == 'a':
+		t, err := goType(sig)
+		if nil != err {
+			return nil, err
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(raw, &elements); nil != err {
+			return nil, err
+		}
+		retval := reflect.MakeSlice(t, 0, len(elements))
+		for _, e := range elements {
+			value, err := decodeValue(sig[1:], e)
+			if nil != err {
+				return nil, err
+			}
+			retval = reflect.Append(retval, reflect.ValueOf(value))
+		}
+		return retval.Interface(), nil
+	case sig[0] == '(':
+		members, err := fields(sig)
+		if nil != err {
+			return nil, err
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(raw, &elements); nil != err {
+			return nil, err
+		}
+		if len(elements) != len(members) {
+			return nil, fmt.Errorf("struct %s has %d members, got %d", sig, len(members), len(elements))
+		}
+		retval := make([]interface{}, 0, len(members))
+		for i, m := range members {
+			value, err := decodeValue(m, elements[i])
+			if nil != err {
+				return nil, err
+			}
+			retval = append(retval, value)
+		}
+		return retval, nil
+	}
+	t, err := goType(sig)
+	if nil != err {
+		return nil, err
+	}
+	value := reflect.New(t)
+	if err := json.Unmarshal(raw, value.Interface()); nil != err {
+		return nil, fmt.Errorf("value %s does not match signature %s: %v", raw, sig, err)
+	}
+	return value.Elem().Interface(), nil
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+)
+
+func replay(t *testing.T, rc RecordedConnection) RecordedConnection {
+	t.Helper()
+	raw, err := json.Marshal(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed RecordedConnection
+	if err := json.Unmarshal(raw, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	return replayed
+}
+
+func TestRecordConnection(t *testing.T) {
+	conn := startFake(t, map[int]*nmtest.Connection{
+		7: nmtest.WifiPsk("home", "uuid-7", "HomeNet", "homesecret"),
+	})
+	for _, withSecrets := range []bool{true, false} {
+		rc, err := RecordConnection(context.Background(), 7, conn, withSecrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc = replay(t, rc)
+		ns, err := rc.NetworkSetting()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns.Id != "home" || string(ns.Ssid) != "HomeNet" || ns.DbusId != 7 || !ns.IsPsk {
+			t.Errorf("unexpected settings %+v", ns)
+		}
+		err = rc.AddSecrets(&ns)
+		if !withSecrets {
+			if !errors.Is(err, ErrNoSecret) || ns.Key != "" || ns.KeySource != SecretSourceNone {
+				t.Errorf("expected the redacted secret to be missing, got %v, key %q from %v", err, ns.Key, ns.KeySource)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ns.Key != "homesecret" || ns.KeySource != SecretSourceFile {
+			t.Errorf("unexpected key %q from %v", ns.Key, ns.KeySource)
+		}
+	}
+}
+
+func TestRecordConnectionError(t *testing.T) {
+	denied := nmtest.WifiPsk("denied", "uuid-1", "DeniedNet", "secret")
+	denied.SecretsError = nmtest.PermissionDenied()
+	conn := startFake(t, map[int]*nmtest.Connection{1: denied})
+	rc, err := RecordConnection(context.Background(), 1, conn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc = replay(t, rc)
+	ns, err := rc.NetworkSetting()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.AddSecrets(&ns); !errors.Is(err, ErrPermissionDenied) || !ns.PermissionDenied {
+		t.Errorf("expected replayed permission denied, got %v", err)
+	}
+	if _, err := (RecordedConnection{DbusId: 2, SettingsError: newRecordedError(errors.New("x"))}).NetworkSetting(); err == nil {
+		t.Errorf("expected replayed settings error")
+	}
+}
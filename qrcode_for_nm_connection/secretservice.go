@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus"
+)
+
+// SecretSource describes where the key of a NetworkSetting was obtained.
+type SecretSource string
+
+const (
+	SecretSourceNone           SecretSource = ""
+	SecretSourceNetworkManager SecretSource = "networkmanager"
+	SecretSourceSecretService  SecretSource = "secret-service"
+	SecretSourcePrompt         SecretSource = "prompt"
+	SecretSourceFile           SecretSource = "file"
+)
+
+func (s SecretSource) String() string {
+	switch s {
+	case SecretSourceNone:
+		return "none"
+	case SecretSourceNetworkManager:
+		return "NetworkManager system settings"
+	case SecretSourceSecretService:
+		return "Secret Service (user keyring)"
+	case SecretSourcePrompt:
+		return "interactive prompt"
+	case SecretSourceFile:
+		return "file"
+	}
+	return string(s)
+}
+
+const (
+	secretServiceName  = "org.freedesktop.secrets"
+	secretServicePath  = "/org/freedesktop/secrets"
+	secretServiceIface = "org.freedesktop.Secret.Service"
+)
+
+// secretServiceBus connects to the bus on which the Secret Service is
+// looked for.
+var secretServiceBus = dbus.SessionBus
+
+// secretServiceSecret is the (oayays) Secret struct of the Secret Service API.
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretServiceKey looks up a secret which a NetworkManager secret agent
+// (such as nm-applet or gnome-shell) stored in the user's keyring. Such
+// secrets have the psk-flags agent-owned and are not handed out by
+// NetworkManager to plain clients.
+func SecretServiceKey(ctx context.Context, conn *dbus.Conn, uuid, settingName, settingKey string) (string, error) {
+	service := conn.Object(secretServiceName, secretServicePath)
+
+	// these are the attributes under which libnm based agents store secrets
+	attributes := map[string]string{
+		"connection-uuid": uuid,
+		"setting-name":    settingName,
+		"setting-key":     settingKey,
+	}
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.CallWithContext(ctx, secretServiceIface+".SearchItems", 0, attributes).Store(&unlocked, &locked); nil != err {
+		return "", fmt.Errorf("searching secret service: %v", err)
+	}
+	if len(unlocked) == 0 {
+		if len(locked) != 0 {
+			return "", fmt.Errorf("keyring holding %s of %s is locked", settingKey, uuid)
+		}
+		return "", fmt.Errorf("no %s for %s in the keyring", settingKey, uuid)
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.CallWithContext(ctx, secretServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); nil != err {
+		return "", fmt.Errorf("opening secret service session: %v", err)
+	}
+	defer conn.Object(secretServiceName, session).Go("org.freedesktop.Secret.Session.Close", dbus.FlagNoReplyExpected, nil)
+
+	var secret secretServiceSecret
+	if err := conn.Object(secretServiceName, unlocked[0]).CallWithContext(ctx, "org.freedesktop.Secret.Item.GetSecret", 0, session).Store(&secret); nil != err {
+		return "", fmt.Errorf("reading secret from secret service: %v", err)
+	}
+	return string(secret.Value), nil
+}
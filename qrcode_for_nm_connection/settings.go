@@ -0,0 +1,248 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// typeNames are the names nmcli uses for connection types where they differ
+// from NetworkManager's setting names.
+var typeNames = map[string]string{
+	"802-3-ethernet":   "ethernet",
+	"802-11-wireless":  "wifi",
+	"802-11-olpc-mesh": "olpc-mesh",
+}
+
+// TypeName returns the short (nmcli) name of a NetworkManager connection
+// type, e.g. "ethernet" for "802-3-ethernet". Short names are returned
+// unchanged.
+func TypeName(connectionType string) string {
+	if name, found := typeNames[connectionType]; found {
+		return name
+	}
+	return connectionType
+}
+
+// TypeName is the short name of the connection's type.
+func (ns NetworkSetting) TypeName() string {
+	return TypeName(ns.Connection.Type)
+}
+
+// ConnectionSettings is the "connection" settings block.
+type ConnectionSettings struct {
+	Id                  string   `dbus:"id,required"`
+	Uuid                string   `dbus:"uuid"`
+	Type                string   `dbus:"type"`
+	InterfaceName       string   `dbus:"interface-name"`
+	Autoconnect         bool     `dbus:"autoconnect"`
+	AutoconnectPriority int32    `dbus:"autoconnect-priority"`
+	Timestamp           uint64   `dbus:"timestamp"` // last successful activation, seconds since the epoch
+	Permissions         []string `dbus:"permissions"`
+}
+
+// WirelessSettings is the "802-11-wireless" settings block.
+type WirelessSettings struct {
+	Ssid       []byte `dbus:"ssid,required"`
+	Mode       string `dbus:"mode"` // infrastructure, adhoc, ap or mesh
+	Band       string `dbus:"band"` // a (5GHz) or bg (2.4GHz), empty for any
+	Channel    uint32 `dbus:"channel"`
+	Bssid      []byte `dbus:"bssid"`
+	MacAddress []byte `dbus:"mac-address"`
+	// AssignedMacAddress is the MAC address to use or one of "preserve",
+	// "permanent", "random" and "stable".
+	AssignedMacAddress string `dbus:"assigned-mac-address"`
+	Hidden             bool   `dbus:"hidden"`
+	Mtu                uint32 `dbus:"mtu"`
+	Powersave          uint32 `dbus:"powersave"`
+}
+
+// Pmf is the setting for protected management frames (802.11w).
+type Pmf int32
+
+const (
+	PmfDefault  Pmf = 0 // use the global default
+	PmfDisable  Pmf = 1
+	PmfOptional Pmf = 2
+	PmfRequired Pmf = 3
+)
+
+func (p Pmf) String() string {
+	switch p {
+	case PmfDefault:
+		return "default"
+	case PmfDisable:
+		return "disable"
+	case PmfOptional:
+		return "optional"
+	case PmfRequired:
+		return "required"
+	}
+	return fmt.Sprintf("unknown (%d)", int32(p))
+}
+
+// SecuritySettings is the "802-11-wireless-security" settings block
+// without the secrets.
+type SecuritySettings struct {
+	KeyMgmt           string      `dbus:"key-mgmt,required"` // none (WEP), ieee8021x, wpa-psk, sae, owe, wpa-eap, ...
+	AuthAlg           string      `dbus:"auth-alg"`
+	Proto             []string    `dbus:"proto"`    // wpa, rsn
+	Pairwise          []string    `dbus:"pairwise"` // tkip, ccmp
+	Group             []string    `dbus:"group"`    // wep40, wep104, tkip, ccmp
+	Pmf               Pmf         `dbus:"pmf"`
+	PskFlags          SecretFlags `dbus:"psk-flags"`
+	WepKeyFlags       SecretFlags `dbus:"wep-key-flags"`
+	WepKeyType        uint32      `dbus:"wep-key-type"`
+	WepTxKeyidx       uint32      `dbus:"wep-tx-keyidx"`
+	LeapPasswordFlags SecretFlags `dbus:"leap-password-flags"`
+}
+
+// Ieee8021xSettings is the "802-1x" settings block of WPA enterprise
+// connections without the password.
+type Ieee8021xSettings struct {
+	Eap               []string `dbus:"eap"` // peap, ttls, tls, pwd, leap, fast
+	Identity          string   `dbus:"identity"`
+	AnonymousIdentity string   `dbus:"anonymous-identity"`
+	Phase2Auth        string   `dbus:"phase2-auth"` // pap, chap, mschap, mschapv2, gtc, ...
+	Phase2Autheap     string   `dbus:"phase2-autheap"`
+	// CaCert is a DER or PEM certificate or a NUL terminated file:// URI
+	// of one.
+	CaCert            []byte      `dbus:"ca-cert"`
+	DomainSuffixMatch string      `dbus:"domain-suffix-match"`
+	DomainMatch       string      `dbus:"domain-match"`
+	PasswordFlags     SecretFlags `dbus:"password-flags"`
+}
+
+// CaCertPath returns the file name if the CA certificate is given as
+// file:// URI.
+func (s Ieee8021xSettings) CaCertPath() (string, bool) {
+	const scheme = "file://"
+	if !strings.HasPrefix(string(s.CaCert), scheme) {
+		return "", false
+	}
+	return strings.TrimRight(string(s.CaCert[len(scheme):]), "\x00"), true
+}
+
+// IsEap tells if the connection authenticates with 802.1x (WPA enterprise).
+func (ns NetworkSetting) IsEap() bool {
+	switch ns.Security.KeyMgmt {
+	case "wpa-eap", "wpa-eap-suite-b-192", "ieee8021x":
+		return true
+	}
+	return false
+}
+
+// IsWep tells if the connection uses a static WEP key, NetworkManager's
+// key-mgmt "none".
+func (ns NetworkSetting) IsWep() bool {
+	return ns.Security.KeyMgmt == "none"
+}
+
+func (f SecretFlags) String() string {
+	if f == SecretFlagNone {
+		return "none"
+	}
+	var names []string
+	for _, flag := range []struct {
+		flag SecretFlags
+		name string
+	}{
+		{SecretFlagAgentOwned, "agent-owned"},
+		{SecretFlagNotSaved, "not-saved"},
+		{SecretFlagNotRequired, "not-required"},
+	} {
+		if f&flag.flag != 0 {
+			names = append(names, flag.name)
+			f &^= flag.flag
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(f)))
+	}
+	return strings.Join(names, "|")
+}
+
+// IPSettings are the parts of the "ipv4" and "ipv6" settings blocks which
+// are needed to configure a VPN peer or to move a connection to another
+// machine.
+type IPSettings struct {
+	Method    string   `dbus:"method"`
+	Addresses []string // address/prefix
+	Gateway   string   `dbus:"gateway"`
+	Dns       []string
+	DnsSearch []string `dbus:"dns-search"`
+}
+
+// decodeIPSettings reads the "ipv4" or "ipv6" settings block. Addresses are
+// taken from address-data, DNS servers from dns-data or, with older
+// NetworkManager versions, from dns.
+func decodeIPSettings(settings map[string]map[string]dbus.Variant, name string) (IPSettings, error) {
+	var retval IPSettings
+	var raw struct {
+		AddressData []map[string]dbus.Variant `dbus:"address-data"`
+		DnsData     []string                  `dbus:"dns-data"`
+	}
+	if _, err := decodeBlock(settings, name, &retval); nil != err {
+		return retval, err
+	}
+	if _, err := decodeBlock(settings, name, &raw); nil != err {
+		return retval, err
+	}
+	for i, data := range raw.AddressData {
+		var address struct {
+			Address string `dbus:"address,required"`
+			Prefix  uint32 `dbus:"prefix,required"`
+		}
+		if err := decodeFields(fmt.Sprintf("%s.address-data[%d]", name, i), data, &address); nil != err {
+			return retval, err
+		}
+		retval.Addresses = append(retval.Addresses, fmt.Sprintf("%s/%d", address.Address, address.Prefix))
+	}
+	if len(raw.DnsData) > 0 {
+		retval.Dns = raw.DnsData
+		return retval, nil
+	}
+	// the type of dns depends on the block
+	var err error
+	if name == "ipv4" {
+		// network byte order integers
+		var dns struct {
+			Dns []uint32 `dbus:"dns"`
+		}
+		_, err = decodeBlock(settings, name, &dns)
+		for _, a := range dns.Dns {
+			retval.Dns = append(retval.Dns, net.IPv4(byte(a), byte(a>>8), byte(a>>16), byte(a>>24)).String())
+		}
+	} else {
+		var dns struct {
+			Dns [][]byte `dbus:"dns"`
+		}
+		_, err = decodeBlock(settings, name, &dns)
+		for _, a := range dns.Dns {
+			if len(a) == net.IPv6len {
+				retval.Dns = append(retval.Dns, net.IP(a).String())
+			}
+		}
+	}
+	return retval, err
+}
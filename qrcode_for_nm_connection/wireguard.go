@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus"
+)
+
+// WireGuardSettings is the "wireguard" settings block without the private
+// key, which is stored in NetworkSetting.Key.
+type WireGuardSettings struct {
+	PrivateKeyFlags SecretFlags `dbus:"private-key-flags"`
+	ListenPort      uint32      `dbus:"listen-port"`
+	Fwmark          uint32      `dbus:"fwmark"`
+	Mtu             uint32      `dbus:"mtu"`
+	Peers           []WireGuardPeer
+}
+
+// WireGuardPeer is an entry of the peers of the "wireguard" settings block.
+type WireGuardPeer struct {
+	PublicKey           string      `dbus:"public-key,required"`
+	Endpoint            string      `dbus:"endpoint"`
+	AllowedIps          []string    `dbus:"allowed-ips"`
+	PresharedKey        string      `dbus:"preshared-key"`
+	PresharedKeyFlags   SecretFlags `dbus:"preshared-key-flags"`
+	PersistentKeepalive uint32      `dbus:"persistent-keepalive"`
+}
+
+// IsWireGuard tells if the connection is a WireGuard connection.
+func (ns NetworkSetting) IsWireGuard() bool {
+	return ns.Connection.Type == "wireguard"
+}
+
+// decodeWireGuard reads the "wireguard" settings block and its peers, the
+// secrets reply of GetSecrets("wireguard") has the same layout.
+func decodeWireGuard(settings map[string]map[string]dbus.Variant, wg *WireGuardSettings) (found bool, err error) {
+	found, err = decodeBlock(settings, "wireguard", wg)
+	if !found || nil != err {
+		return found, err
+	}
+	var raw struct {
+		Peers []map[string]dbus.Variant `dbus:"peers"`
+	}
+	if _, err := decodeBlock(settings, "wireguard", &raw); nil != err {
+		return true, err
+	}
+	wg.Peers = make([]WireGuardPeer, len(raw.Peers))
+	for i, data := range raw.Peers {
+		if err := decodeFields(fmt.Sprintf("wireguard.peers[%d]", i), data, &wg.Peers[i]); nil != err {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (ns *NetworkSetting) addWireGuardSettings(settings map[string]map[string]dbus.Variant) error {
+	found, err := decodeWireGuard(settings, &ns.WireGuard)
+	if nil != err {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Could not resolve \"wireguard\" block of %s", ns.Id)
+	}
+	if ns.Ipv4, err = decodeIPSettings(settings, "ipv4"); nil != err {
+		return err
+	}
+	ns.Ipv6, err = decodeIPSettings(settings, "ipv6")
+	return err
+}
+
+func (ns *NetworkSetting) addWireGuardSecrets(secrets map[string]map[string]dbus.Variant) error {
+	var wg WireGuardSettings
+	found, err := decodeWireGuard(secrets, &wg)
+	if nil != err {
+		return err
+	}
+	var key struct {
+		PrivateKey string `dbus:"private-key"`
+	}
+	if _, err := decodeBlock(secrets, "wireguard", &key); nil != err {
+		return err
+	}
+	if !found || key.PrivateKey == "" {
+		return fmt.Errorf("%w: No private key in wireguard block", ErrNoSecret)
+	}
+	ns.Key = key.PrivateKey
+	for _, secret := range wg.Peers {
+		for i := range ns.WireGuard.Peers {
+			if ns.WireGuard.Peers[i].PublicKey == secret.PublicKey && secret.PresharedKey != "" {
+				ns.WireGuard.Peers[i].PresharedKey = secret.PresharedKey
+			}
+		}
+	}
+	return nil
+}
+
+// WireGuardConfig renders a WireGuard connection as wg-quick configuration,
+// which the WireGuard mobile apps import from a QR code. Only keys the apps
+// understand are written.
+func WireGuardConfig(ns NetworkSetting) string {
+	var b strings.Builder
+	wg := ns.WireGuard
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", ns.Key)
+	addresses := append(append([]string{}, ns.Ipv4.Addresses...), ns.Ipv6.Addresses...)
+	if len(addresses) > 0 {
+		fmt.Fprintf(&b, "Address = %s\n", strings.Join(addresses, ", "))
+	}
+	dns := append(append([]string{}, ns.Ipv4.Dns...), ns.Ipv6.Dns...)
+	dns = append(append(dns, ns.Ipv4.DnsSearch...), ns.Ipv6.DnsSearch...)
+	if len(dns) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(dns, ", "))
+	}
+	if wg.ListenPort != 0 {
+		fmt.Fprintf(&b, "ListenPort = %d\n", wg.ListenPort)
+	}
+	if wg.Mtu != 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", wg.Mtu)
+	}
+	for _, peer := range wg.Peers {
+		b.WriteString("\n[Peer]\n")
+		fmt.Fprintf(&b, "PublicKey = %s\n", peer.PublicKey)
+		if peer.PresharedKey != "" {
+			fmt.Fprintf(&b, "PresharedKey = %s\n", peer.PresharedKey)
+		}
+		if len(peer.AllowedIps) > 0 {
+			fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(peer.AllowedIps, ", "))
+		}
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&b, "Endpoint = %s\n", peer.Endpoint)
+		}
+		if peer.PersistentKeepalive != 0 {
+			fmt.Fprintf(&b, "PersistentKeepalive = %d\n", peer.PersistentKeepalive)
+		}
+	}
+	return b.String()
+}
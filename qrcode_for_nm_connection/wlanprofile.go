@@ -0,0 +1,181 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// wlanProfile is the WLANProfile XML schema of Windows, as used by
+// netsh wlan add profile and netsh wlan export profile.
+type wlanProfile struct {
+	XMLName    xml.Name `xml:"http://www.microsoft.com/networking/WLAN/profile/v1 WLANProfile"`
+	Name       string   `xml:"name"`
+	SSIDConfig struct {
+		SSID struct {
+			Hex  string `xml:"hex,omitempty"`
+			Name string `xml:"name"`
+		} `xml:"SSID"`
+		NonBroadcast bool `xml:"nonBroadcast,omitempty"`
+	} `xml:"SSIDConfig"`
+	ConnectionType string `xml:"connectionType"`
+	ConnectionMode string `xml:"connectionMode"`
+	MSM            struct {
+		Security struct {
+			AuthEncryption struct {
+				Authentication string `xml:"authentication"`
+				Encryption     string `xml:"encryption"`
+				UseOneX        bool   `xml:"useOneX"`
+			} `xml:"authEncryption"`
+			SharedKey *wlanSharedKey `xml:"sharedKey,omitempty"`
+		} `xml:"security"`
+	} `xml:"MSM"`
+}
+
+type wlanSharedKey struct {
+	KeyType     string `xml:"keyType"` // passPhrase or networkKey
+	Protected   bool   `xml:"protected"`
+	KeyMaterial string `xml:"keyMaterial"`
+}
+
+// wlanAuthEncryption maps the security settings to WLANProfile
+// authentication and encryption.
+func wlanAuthEncryption(ns NetworkSetting) (string, string, error) {
+	if ns.IsEap() {
+		return "", "", fmt.Errorf("%w: WLAN profiles of 802.1x connections (%s) are not supported", ErrUnsupportedType, ns.Id)
+	}
+	s := ns.Security
+	switch {
+	case ns.IsWep():
+		return "open", "WEP", nil
+	case s.KeyMgmt == "":
+		return "open", "none", nil
+	case s.KeyMgmt == "owe":
+		return "OWE", "AES", nil
+	case s.KeyMgmt == "sae":
+		return "WPA3SAE", "AES", nil
+	case s.KeyMgmt != "wpa-psk":
+		return "", "", fmt.Errorf("%w: WLAN profiles with key-mgmt %s (%s) are not supported", ErrUnsupportedType, s.KeyMgmt, ns.Id)
+	}
+	authentication, encryption := "WPA2PSK", "AES"
+	if len(s.Proto) == 1 && s.Proto[0] == "wpa" {
+		authentication = "WPAPSK"
+	}
+	if len(s.Pairwise) == 1 && s.Pairwise[0] == "tkip" {
+		encryption = "TKIP"
+	}
+	return authentication, encryption, nil
+}
+
+// WLANProfile renders a Wi-Fi connection as Windows WLAN profile, to be
+// imported with netsh wlan add profile filename=...
+func WLANProfile(ns NetworkSetting) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if ns.IsWireGuard() {
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	authentication, encryption, err := wlanAuthEncryption(ns)
+	if nil != err {
+		return nil, err
+	}
+	var p wlanProfile
+	p.Name = ns.Id
+	p.SSIDConfig.SSID.Hex = strings.ToUpper(hex.EncodeToString(ns.Ssid))
+	p.SSIDConfig.SSID.Name = string(ns.Ssid)
+	p.SSIDConfig.NonBroadcast = ns.IsHidden
+	p.ConnectionType = "ESS"
+	p.ConnectionMode = "manual"
+	if ns.Connection.Autoconnect {
+		p.ConnectionMode = "auto"
+	}
+	p.MSM.Security.AuthEncryption.Authentication = authentication
+	p.MSM.Security.AuthEncryption.Encryption = encryption
+	if ns.IsPsk {
+		keyType := "passPhrase"
+		if ns.IsWep() || (len(ns.Key) == 64 && isHex(ns.Key)) {
+			keyType = "networkKey"
+		}
+		p.MSM.Security.SharedKey = &wlanSharedKey{KeyType: keyType, KeyMaterial: ns.Key}
+	}
+	out, err := xml.MarshalIndent(p, "", "\t")
+	if nil != err {
+		return nil, err
+	}
+	return []byte(xml.Header + string(out) + "\n"), nil
+}
+
+// ParseWLANProfile reads a Windows WLAN profile, e.g. from netsh wlan export
+// profile key=clear. Keys exported without key=clear are encrypted for the
+// exporting machine and therefore left empty.
+func ParseWLANProfile(r io.Reader) ([]NetworkSetting, error) {
+	var p wlanProfile
+	if err := xml.NewDecoder(r).Decode(&p); nil != err {
+		return nil, fmt.Errorf("reading WLAN profile: %v", err)
+	}
+	var ns NetworkSetting
+	ns.Ssid = []byte(p.SSIDConfig.SSID.Name)
+	if p.SSIDConfig.SSID.Hex != "" {
+		ssid, err := hex.DecodeString(p.SSIDConfig.SSID.Hex)
+		if nil != err {
+			return nil, fmt.Errorf("reading WLAN profile: invalid SSID hex %q", p.SSIDConfig.SSID.Hex)
+		}
+		ns.Ssid = ssid
+	}
+	if len(ns.Ssid) == 0 {
+		return nil, fmt.Errorf("reading WLAN profile: no SSID")
+	}
+	ns.Id = p.Name
+	if ns.Id == "" {
+		ns.Id = string(ns.Ssid)
+	}
+	ns.IsHidden = p.SSIDConfig.NonBroadcast
+	ns.Connection = ConnectionSettings{Id: ns.Id, Type: "802-11-wireless", Autoconnect: p.ConnectionMode != "manual"}
+	ns.Wireless = WirelessSettings{Ssid: ns.Ssid, Mode: "infrastructure", Hidden: ns.IsHidden}
+
+	ae := p.MSM.Security.AuthEncryption
+	switch {
+	case ae.UseOneX || strings.HasSuffix(ae.Authentication, "ENT") || ae.Authentication == "WPA" || ae.Authentication == "WPA2":
+		return nil, fmt.Errorf("%w: 802.1x WLAN profile %s", ErrUnsupportedType, ns.Id)
+	case ae.Encryption == "WEP":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WEP", true, "none"
+	case ae.Authentication == "open":
+	case ae.Authentication == "OWE":
+		ns.Security.KeyMgmt = "owe"
+	case ae.Authentication == "WPA3SAE":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "sae"
+	case ae.Authentication == "WPAPSK" || ae.Authentication == "WPA2PSK":
+		ns.Sec, ns.IsPsk, ns.Security.KeyMgmt = "WPA", true, "wpa-psk"
+		if ae.Authentication == "WPAPSK" {
+			ns.Security.Proto = []string{"wpa"}
+		}
+	default:
+		return nil, fmt.Errorf("reading WLAN profile: unknown authentication %q", ae.Authentication)
+	}
+	if key := p.MSM.Security.SharedKey; nil != key && !key.Protected && ns.IsPsk {
+		ns.Key = key.KeyMaterial
+		ns.KeySource = SecretSourceFile
+	}
+	return []NetworkSetting{ns}, nil
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+)
+
+func TestWLANProfile(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Key = "secret<&>"
+	profile, err := WLANProfile(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<hex>4E6574</hex>", "<nonBroadcast>true</nonBroadcast>", "<authentication>WPA2PSK</authentication>", "<keyMaterial>secret&lt;&amp;&gt;</keyMaterial>"} {
+		if !strings.Contains(string(profile), want) {
+			t.Errorf("profile lacks %s:\n%s", want, profile)
+		}
+	}
+	parsed, err := ParseWLANProfile(bytes.NewReader(profile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 1 || NetworkCode(parsed[0]) != NetworkCode(ns) {
+		t.Errorf("round trip changed the connection to %+v", parsed)
+	}
+
+	ns.Security.KeyMgmt = "wpa-eap"
+	if _, err := WLANProfile(ns); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected 802.1x to be unsupported, got %v", err)
+	}
+}
+
+func TestWLANProfileWep(t *testing.T) {
+	ns := settingOf(t, nmtest.WifiWep("legacy", "uuid-4", "OldNet", "abcde", 0))
+	profile, err := WLANProfile(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<authentication>open</authentication>", "<encryption>WEP</encryption>", "<keyType>networkKey</keyType>", "<keyMaterial>abcde</keyMaterial>"} {
+		if !strings.Contains(string(profile), want) {
+			t.Errorf("profile lacks %s:\n%s", want, profile)
+		}
+	}
+
+	ns.Security.KeyMgmt = "wapi-psk"
+	if _, err := WLANProfile(ns); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected an unknown key-mgmt to be unsupported, got %v", err)
+	}
+}
+
+// as written by netsh wlan export profile without key=clear
+const windowsExport = `<?xml version="1.0"?>
+<WLANProfile xmlns="http://www.microsoft.com/networking/WLAN/profile/v1">
+	<name>Office</name>
+	<SSIDConfig>
+		<SSID>
+			<hex>4F6666696365</hex>
+			<name>Office</name>
+		</SSID>
+	</SSIDConfig>
+	<connectionType>ESS</connectionType>
+	<connectionMode>manual</connectionMode>
+	<MSM>
+		<security>
+			<authEncryption>
+				<authentication>WPA3SAE</authentication>
+				<encryption>AES</encryption>
+				<useOneX>false</useOneX>
+				<transitionMode xmlns="http://www.microsoft.com/networking/WLAN/profile/v4">true</transitionMode>
+			</authEncryption>
+			<sharedKey>
+				<keyType>passPhrase</keyType>
+				<protected>true</protected>
+				<keyMaterial>01000000D08C9DDF0115D1118C7A00C04FC297EB</keyMaterial>
+			</sharedKey>
+		</security>
+	</MSM>
+</WLANProfile>`
+
+func TestParseWLANProfile(t *testing.T) {
+	parsed, err := ParseWLANProfile(strings.NewReader(windowsExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := parsed[0]
+	if ns.Id != "Office" || string(ns.Ssid) != "Office" || ns.Connection.Autoconnect {
+		t.Errorf("unexpected connection %+v", ns)
+	}
+	if !ns.IsPsk || ns.Security.KeyMgmt != "sae" || ns.Key != "" {
+		t.Errorf("unexpected security %+v, key %q", ns.Security, ns.Key)
+	}
+}
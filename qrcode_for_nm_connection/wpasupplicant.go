@@ -0,0 +1,230 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// wpa_supplicant key_mgmt values for NetworkManager's key-mgmt
+var wpaKeyMgmt = map[string]string{
+	"":                    "NONE",
+	"none":                "NONE",
+	"ieee8021x":           "IEEE8021X",
+	"wpa-psk":             "WPA-PSK",
+	"sae":                 "SAE",
+	"owe":                 "OWE",
+	"wpa-eap":             "WPA-EAP",
+	"wpa-eap-suite-b-192": "WPA-EAP-SUITE-B-192",
+}
+
+// wpa_supplicant modes for NetworkManager's mode, infrastructure is the
+// default
+var wpaModes = map[string]int{
+	"adhoc": 1,
+	"ap":    2,
+	"mesh":  5,
+}
+
+// wpaString quotes a string value of wpa_supplicant.conf, values which
+// cannot be quoted are written in hex.
+func wpaString(value []byte) string {
+	for _, c := range value {
+		if c < 0x20 || c > 0x7e || c == '"' {
+			return hex.EncodeToString(value)
+		}
+	}
+	return `"` + string(value) + `"`
+}
+
+// wpaPsk writes a passphrase quoted, or as the derived 256 bit key like
+// wpa_passphrase if it cannot be quoted. A 64 digit hex key is written as
+// is.
+func wpaPsk(passphrase string, ssid []byte) (string, error) {
+	if _, err := hex.DecodeString(passphrase); nil == err && len(passphrase) == 64 {
+		return passphrase, nil
+	}
+	if len(passphrase) < 8 || len(passphrase) > 63 {
+		return "", fmt.Errorf("a passphrase has 8 to 63 characters, not %d", len(passphrase))
+	}
+	if !strings.ContainsAny(passphrase, "\n\r\x00") {
+		return `"` + passphrase + `"`, nil
+	}
+	psk, err := pbkdf2.Key(sha1.New, passphrase, ssid, 4096, 32)
+	return hex.EncodeToString(psk), err
+}
+
+// wpaWepKey writes a WEP key: hex keys as they are, ASCII keys quoted and
+// passphrases (wep-key-type 2) hashed to a 104 bit key like NetworkManager
+// does, since wpa_supplicant takes no passphrases for WEP.
+func wpaWepKey(key string, keyType uint32) (string, error) {
+	if keyType == 2 {
+		var repeated []byte
+		for len(repeated) < 64 {
+			repeated = append(repeated, key...)
+		}
+		sum := md5.Sum(repeated[:64])
+		return hex.EncodeToString(sum[:13]), nil
+	}
+	if _, err := hex.DecodeString(key); nil == err && (len(key) == 10 || len(key) == 26) {
+		return key, nil
+	}
+	if len(key) == 5 || len(key) == 13 {
+		return wpaString([]byte(key)), nil
+	}
+	return "", fmt.Errorf("a key has 5 or 13 characters or 10 or 26 hex digits, not %d characters", len(key))
+}
+
+func wpaUpper(values []string) string {
+	return strings.ToUpper(strings.Join(values, " "))
+}
+
+// WpaSupplicant renders a Wi-Fi connection as network block of
+// wpa_supplicant.conf. The CA certificate of an 802.1x connection is
+// referred to by its path or, if NetworkManager stores it, embedded as
+// blob.
+func WpaSupplicant(ns NetworkSetting) ([]byte, error) {
+	if err := ns.CheckType(); nil != err {
+		return nil, err
+	}
+	if ns.IsWireGuard() {
+		return nil, &UnsupportedTypeError{Id: ns.Id, Type: ns.Connection.Type}
+	}
+	s := ns.Security
+	keyMgmt, found := wpaKeyMgmt[s.KeyMgmt]
+	if !found {
+		return nil, fmt.Errorf("%w: key management %s of %s in wpa_supplicant.conf", ErrUnsupportedType, s.KeyMgmt, ns.Id)
+	}
+	var blobs strings.Builder
+	var b strings.Builder
+	b.WriteString("network={\n")
+	fmt.Fprintf(&b, "\tssid=%s\n", wpaString(ns.Ssid))
+	if ns.IsHidden {
+		b.WriteString("\tscan_ssid=1\n")
+	}
+	if mode, found := wpaModes[ns.Wireless.Mode]; found {
+		fmt.Fprintf(&b, "\tmode=%d\n", mode)
+	}
+	if len(ns.Wireless.Bssid) > 0 {
+		fmt.Fprintf(&b, "\tbssid=%s\n", net.HardwareAddr(ns.Wireless.Bssid))
+	}
+	fmt.Fprintf(&b, "\tkey_mgmt=%s\n", keyMgmt)
+	if len(s.Proto) > 0 {
+		fmt.Fprintf(&b, "\tproto=%s\n", wpaUpper(s.Proto))
+	}
+	if len(s.Pairwise) > 0 {
+		fmt.Fprintf(&b, "\tpairwise=%s\n", wpaUpper(s.Pairwise))
+	}
+	if len(s.Group) > 0 {
+		fmt.Fprintf(&b, "\tgroup=%s\n", wpaUpper(s.Group))
+	}
+	switch {
+	case s.Pmf == PmfDisable:
+		b.WriteString("\tieee80211w=0\n")
+	case s.Pmf == PmfOptional:
+		b.WriteString("\tieee80211w=1\n")
+	case s.Pmf == PmfRequired || s.KeyMgmt == "sae" || s.KeyMgmt == "owe":
+		b.WriteString("\tieee80211w=2\n")
+	}
+	switch {
+	case ns.IsWep():
+		if ns.Key != "" {
+			key, err := wpaWepKey(ns.Key, s.WepKeyType)
+			if nil != err {
+				return nil, fmt.Errorf("WEP key of %s: %v", ns.Id, err)
+			}
+			fmt.Fprintf(&b, "\twep_key%d=%s\n", s.WepTxKeyidx, key)
+			fmt.Fprintf(&b, "\twep_tx_keyidx=%d\n", s.WepTxKeyidx)
+		}
+	case ns.IsPsk:
+		if ns.Key != "" {
+			psk, err := wpaPsk(ns.Key, ns.Ssid)
+			if nil != err {
+				return nil, fmt.Errorf("psk of %s: %v", ns.Id, err)
+			}
+			fmt.Fprintf(&b, "\tpsk=%s\n", psk)
+		}
+	case ns.IsEap():
+		if err := writeWpaEap(&b, &blobs, ns); nil != err {
+			return nil, err
+		}
+	}
+	if p := ns.Connection.AutoconnectPriority; p > 0 {
+		fmt.Fprintf(&b, "\tpriority=%d\n", p)
+	}
+	if !ns.Connection.Autoconnect {
+		b.WriteString("\tdisabled=1\n")
+	}
+	b.WriteString("}\n")
+	return []byte(blobs.String() + b.String()), nil
+}
+
+func writeWpaEap(b, blobs *strings.Builder, ns NetworkSetting) error {
+	s := ns.Ieee8021x
+	if len(s.Eap) == 0 {
+		return fmt.Errorf("no EAP method for %s", ns.Id)
+	}
+	fmt.Fprintf(b, "\teap=%s\n", wpaUpper(s.Eap))
+	for _, field := range []struct{ key, value string }{
+		{"identity", s.Identity},
+		{"anonymous_identity", s.AnonymousIdentity},
+		{"password", ns.Key},
+		{"domain_suffix_match", s.DomainSuffixMatch},
+		{"domain_match", s.DomainMatch},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(b, "\t%s=%s\n", field.key, wpaString([]byte(field.value)))
+		}
+	}
+	var phase2 []string
+	if s.Phase2Auth != "" {
+		phase2 = append(phase2, "auth="+strings.ToUpper(s.Phase2Auth))
+	}
+	if s.Phase2Autheap != "" {
+		phase2 = append(phase2, "autheap="+strings.ToUpper(s.Phase2Autheap))
+	}
+	if len(phase2) > 0 {
+		fmt.Fprintf(b, "\tphase2=%q\n", strings.Join(phase2, " "))
+	}
+	if path, isPath := s.CaCertPath(); isPath {
+		fmt.Fprintf(b, "\tca_cert=%s\n", wpaString([]byte(path)))
+		return nil
+	}
+	cert, err := ns.CaCertificate()
+	if nil != err || nil == cert {
+		return err
+	}
+	name := "ca-" + nameUUID("wpa_supplicant:" + string(ns.Ssid))[:8]
+	fmt.Fprintf(blobs, "blob-base64-%s={\n", name)
+	encoded := base64.StdEncoding.EncodeToString(cert)
+	for len(encoded) > 64 {
+		fmt.Fprintf(blobs, "%s\n", encoded[:64])
+		encoded = encoded[64:]
+	}
+	fmt.Fprintf(blobs, "%s\n}\n\n", encoded)
+	fmt.Fprintf(b, "\tca_cert=\"blob://%s\"\n", name)
+	return nil
+}
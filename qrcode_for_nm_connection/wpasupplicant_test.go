@@ -0,0 +1,141 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package qrcode_for_nm_connection
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+)
+
+func TestWpaSupplicant(t *testing.T) {
+	ns, err := NewNetworkSetting(wifiSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Key = "secret123"
+	block, err := WpaSupplicant(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `network={
+	ssid="Net"
+	scan_ssid=1
+	key_mgmt=WPA-PSK
+	proto=RSN
+	ieee80211w=2
+	psk="secret123"
+}
+`
+	if string(block) != want {
+		t.Errorf("unexpected network block:\n%s", block)
+	}
+
+	ns.Ssid = []byte(`say "hi"`)
+	ns.Connection.Autoconnect = false
+	block, err = WpaSupplicant(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\tssid=7361792022686922\n", "\tdisabled=1\n"} {
+		if !strings.Contains(string(block), want) {
+			t.Errorf("network block lacks %q:\n%s", want, block)
+		}
+	}
+}
+
+func TestWpaPsk(t *testing.T) {
+	// the raw key of the IEEE 802.11i test vector (passphrase "password")
+	const hexKey = "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"
+	for passphrase, want := range map[string]string{
+		"password": `"password"`,
+		hexKey:     hexKey,
+	} {
+		if got, err := wpaPsk(passphrase, []byte("IEEE")); err != nil || got != want {
+			t.Errorf("psk %q written as %s (%v), expected %s", passphrase, got, err, want)
+		}
+	}
+	if got, err := wpaPsk("pass\nword", []byte("IEEE")); err != nil || len(got) != 64 || strings.Contains(got, `"`) {
+		t.Errorf("expected a derived key for a passphrase with a newline, got %s (%v)", got, err)
+	}
+	if _, err := wpaPsk("short", []byte("IEEE")); err == nil {
+		t.Errorf("expected an error for a too short passphrase")
+	}
+}
+
+func TestWpaSupplicantWep(t *testing.T) {
+	ns := settingOf(t, nmtest.WifiWep("legacy", "uuid-4", "Legacy", "0102030405", 1))
+	block, err := WpaSupplicant(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(block), "\twep_key1=0102030405\n\twep_tx_keyidx=1\n") {
+		t.Errorf("expected the hex key unquoted:\n%s", block)
+	}
+	for key, want := range map[string]string{
+		"abcde":      `"abcde"`,
+		`ab"de`:      "6162226465",
+		"0102030405": "0102030405",
+	} {
+		if got, err := wpaWepKey(key, 1); err != nil || got != want {
+			t.Errorf("WEP key %q written as %s (%v), expected %s", key, got, err, want)
+		}
+	}
+	// MD5 of the passphrase repeated to 64 bytes, cut to 104 bits
+	if got, err := wpaWepKey("test", 2); err != nil || got != "9fdf3bfdfb10afeb0925ef9605" {
+		t.Errorf("unexpected hashed key %s (%v)", got, err)
+	}
+	if _, err := wpaWepKey("abcdef", 1); err == nil {
+		t.Errorf("expected an error for a key of invalid length")
+	}
+}
+
+func TestWpaSupplicantEap(t *testing.T) {
+	ns := NetworkSetting{
+		Id:       "corp",
+		Ssid:     []byte("CorpNet"),
+		Sec:      "WPA",
+		Key:      "hunter2",
+		Security: SecuritySettings{KeyMgmt: "wpa-eap"},
+		Ieee8021x: Ieee8021xSettings{
+			Eap:               []string{"peap"},
+			Identity:          "alice",
+			Phase2Auth:        "mschapv2",
+			CaCert:            []byte{0x30, 0x03, 0x02, 0x01, 0x01},
+			DomainSuffixMatch: "radius.example.com",
+		},
+	}
+	ns.Connection.Autoconnect = true
+	block, err := WpaSupplicant(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"={\nMAMCAQE=\n}\n", "\teap=PEAP\n", "\tidentity=\"alice\"\n", "\tpassword=\"hunter2\"\n", "\tphase2=\"auth=MSCHAPV2\"\n", "\tca_cert=\"blob://ca-"} {
+		if !strings.Contains(string(block), want) {
+			t.Errorf("network block lacks %q:\n%s", want, block)
+		}
+	}
+
+	ns.Security.KeyMgmt = "wapi-psk"
+	if _, err := WpaSupplicant(ns); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected unknown key management to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+func yesno(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func mac(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+// writeShow prints all known settings of a connection, meant for finding
+// out why a phone does not accept a code. The secret and the payload are
+// only shown with withSecret, secretErr tells why no secret is available.
+func writeShow(w io.Writer, ns nm2qr.NetworkSetting, withSecret bool, secretErr error) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	c := ns.Connection
+
+	timestamp := "never"
+	if c.Timestamp != 0 {
+		timestamp = time.Unix(int64(c.Timestamp), 0).Format(time.RFC3339)
+	}
+	fmt.Fprintf(tw, "connection\n")
+	fmt.Fprintf(tw, "  id:\t%s\n", c.Id)
+	fmt.Fprintf(tw, "  uuid:\t%s\n", c.Uuid)
+	fmt.Fprintf(tw, "  type:\t%s\n", c.Type)
+	fmt.Fprintf(tw, "  interface-name:\t%s\n", c.InterfaceName)
+	fmt.Fprintf(tw, "  autoconnect:\t%s\n", yesno(c.Autoconnect))
+	fmt.Fprintf(tw, "  autoconnect-priority:\t%d\n", c.AutoconnectPriority)
+	fmt.Fprintf(tw, "  timestamp:\t%s\n", timestamp)
+	fmt.Fprintf(tw, "  permissions:\t%s\n", strings.Join(c.Permissions, ", "))
+
+	if ns.IsWireGuard() {
+		writeShowWireGuard(tw, ns)
+	} else {
+		writeShowWireless(tw, ns)
+	}
+
+	fmt.Fprintf(tw, "qr code\n")
+	if !ns.IsWireGuard() {
+		fmt.Fprintf(tw, "  security:\t%s\n", ns.Sec)
+	}
+	if secretErr != nil {
+		fmt.Fprintf(tw, "  secret:\tunavailable, %v\n", secretErr)
+	} else if ns.NeedsSecret() {
+		secret := "(use -secret to show)"
+		if withSecret {
+			secret = fmt.Sprintf("%q", ns.Key)
+		}
+		fmt.Fprintf(tw, "  secret:\t%s from %v\n", secret, ns.KeySource)
+	}
+	if withSecret && secretErr == nil {
+		payload := strings.TrimSuffix(nm2qr.Payload(ns), "\n")
+		fmt.Fprintf(tw, "  payload:\t%s\n", strings.ReplaceAll(payload, "\n", "\n\t"))
+	}
+	return tw.Flush()
+}
+
+func writeShowWireless(tw io.Writer, ns nm2qr.NetworkSetting) {
+	wl, s := ns.Wireless, ns.Security
+	channel := "any"
+	if wl.Channel != 0 {
+		channel = fmt.Sprint(wl.Channel)
+	}
+	fmt.Fprintf(tw, "802-11-wireless\n")
+	fmt.Fprintf(tw, "  ssid:\t%q (hex %s)\n", wl.Ssid, hex.EncodeToString(wl.Ssid))
+	fmt.Fprintf(tw, "  mode:\t%s\n", wl.Mode)
+	fmt.Fprintf(tw, "  band:\t%s\n", wl.Band)
+	fmt.Fprintf(tw, "  channel:\t%s\n", channel)
+	fmt.Fprintf(tw, "  bssid:\t%s\n", mac(wl.Bssid))
+	fmt.Fprintf(tw, "  mac-address:\t%s\n", mac(wl.MacAddress))
+	fmt.Fprintf(tw, "  assigned-mac-address:\t%s\n", wl.AssignedMacAddress)
+	fmt.Fprintf(tw, "  hidden:\t%s\n", yesno(wl.Hidden))
+	fmt.Fprintf(tw, "  mtu:\t%d\n", wl.Mtu)
+
+	fmt.Fprintf(tw, "802-11-wireless-security\n")
+	fmt.Fprintf(tw, "  key-mgmt:\t%s\n", s.KeyMgmt)
+	fmt.Fprintf(tw, "  auth-alg:\t%s\n", s.AuthAlg)
+	fmt.Fprintf(tw, "  proto:\t%s\n", strings.Join(s.Proto, ", "))
+	fmt.Fprintf(tw, "  pairwise:\t%s\n", strings.Join(s.Pairwise, ", "))
+	fmt.Fprintf(tw, "  group:\t%s\n", strings.Join(s.Group, ", "))
+	fmt.Fprintf(tw, "  pmf:\t%v\n", s.Pmf)
+	fmt.Fprintf(tw, "  psk-flags:\t%v\n", s.PskFlags)
+	fmt.Fprintf(tw, "  wep-key-flags:\t%v\n", s.WepKeyFlags)
+	fmt.Fprintf(tw, "  wep-key-type:\t%d\n", s.WepKeyType)
+	fmt.Fprintf(tw, "  wep-tx-keyidx:\t%d\n", s.WepTxKeyidx)
+	fmt.Fprintf(tw, "  leap-password-flags:\t%v\n", s.LeapPasswordFlags)
+}
+
+func writeShowWireGuard(tw io.Writer, ns nm2qr.NetworkSetting) {
+	wg := ns.WireGuard
+	fmt.Fprintf(tw, "wireguard\n")
+	fmt.Fprintf(tw, "  private-key-flags:\t%v\n", wg.PrivateKeyFlags)
+	fmt.Fprintf(tw, "  listen-port:\t%d\n", wg.ListenPort)
+	fmt.Fprintf(tw, "  fwmark:\t%d\n", wg.Fwmark)
+	fmt.Fprintf(tw, "  mtu:\t%d\n", wg.Mtu)
+	for i, peer := range wg.Peers {
+		fmt.Fprintf(tw, "  peer %d\n", i)
+		fmt.Fprintf(tw, "    public-key:\t%s\n", peer.PublicKey)
+		fmt.Fprintf(tw, "    endpoint:\t%s\n", peer.Endpoint)
+		fmt.Fprintf(tw, "    allowed-ips:\t%s\n", strings.Join(peer.AllowedIps, ", "))
+		fmt.Fprintf(tw, "    preshared-key-flags:\t%v\n", peer.PresharedKeyFlags)
+		fmt.Fprintf(tw, "    persistent-keepalive:\t%d\n", peer.PersistentKeepalive)
+	}
+	for _, ip := range []struct {
+		name     string
+		settings nm2qr.IPSettings
+	}{{"ipv4", ns.Ipv4}, {"ipv6", ns.Ipv6}} {
+		fmt.Fprintf(tw, "%s\n", ip.name)
+		fmt.Fprintf(tw, "  method:\t%s\n", ip.settings.Method)
+		fmt.Fprintf(tw, "  addresses:\t%s\n", strings.Join(ip.settings.Addresses, ", "))
+		fmt.Fprintf(tw, "  dns:\t%s\n", strings.Join(ip.settings.Dns, ", "))
+		fmt.Fprintf(tw, "  dns-search:\t%s\n", strings.Join(ip.settings.DnsSearch, ", "))
+	}
+}
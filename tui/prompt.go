@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ui "github.com/gizak/termui"
+	"github.com/gizak/termui/widgets"
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+type dialogState int
+
+const (
+	dialogOpen dialogState = iota
+	dialogAccepted
+	dialogCancelled
+)
+
+// passwordDialog asks for the key of a network whose secret could not be
+// retrieved. The input is not echoed.
+type passwordDialog struct {
+	*widgets.Paragraph
+	network nm2qr.NetworkSetting
+	action  string // the key which triggered the dialog
+	input   []rune
+	message string
+}
+
+func newPasswordDialog(ns nm2qr.NetworkSetting, action string) *passwordDialog {
+	d := &passwordDialog{
+		Paragraph: widgets.NewParagraph(),
+		network:   ns,
+		action:    action,
+	}
+	d.Title = fmt.Sprintf("password for %s", ns.Id)
+	d.SetRect(35, 12, 85, 19)
+	d.BorderStyle = ui.NewStyle(ui.ColorYellow)
+	d.update()
+	return d
+}
+
+func (d *passwordDialog) update() {
+	reason := "no secret stored"
+	if d.network.PermissionDenied {
+		reason = "permission to read the secret denied"
+	}
+	d.Text = fmt.Sprintf("%s for SSID %s\n\n> %s\n\n%s", reason, d.network.Ssid, strings.Repeat("*", len(d.input)), d.message)
+}
+
+// handle processes a key press. The entered key is only accepted if it is
+// valid for the network's security type.
+func (d *passwordDialog) handle(e ui.Event) dialogState {
+	switch e.ID {
+	case "<Escape>", "<C-c>":
+		return dialogCancelled
+	case "<Enter>":
+		key := string(d.input)
+		if err := nm2qr.ValidateKey(d.network.Sec, key); nil != err {
+			d.message = err.Error()
+			d.update()
+			return dialogOpen
+		}
+		d.network.Key = key
+		d.network.KeySource = nm2qr.SecretSourcePrompt
+		return dialogAccepted
+	case "<Backspace>", "<C-<Backspace>>":
+		if len(d.input) > 0 {
+			d.input = d.input[:len(d.input)-1]
+		}
+	case "<Space>":
+		d.input = append(d.input, ' ')
+	default:
+		if r := []rune(e.ID); len(r) == 1 {
+			d.input = append(d.input, r[0])
+		}
+	}
+	d.message = ""
+	d.update()
+	return dialogOpen
+}
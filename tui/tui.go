@@ -19,14 +19,16 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"sort"
 	"strings"
+	"time"
 
 	ui "github.com/gizak/termui"
 	"github.com/gizak/termui/widgets"
-	"github.com/godbus/dbus"
 	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
 	ux "github.com/pseyfert/go-networkmanager-qrcode-generator/ux"
 	"github.com/skip2/go-qrcode"
@@ -40,41 +42,88 @@ func dbusmap(cons []nm2qr.NetworkSetting) map[int]nm2qr.NetworkSetting {
 	return retval
 }
 
-func sortedids(cons map[int]nm2qr.NetworkSetting) []int {
-	keys := make([]int, 0, len(cons))
+func skippedmap(failures []ux.ConnectionError) map[int]ux.ConnectionError {
+	retval := make(map[int]ux.ConnectionError)
+	for _, f := range failures {
+		retval[f.DbusId] = f
+	}
+	return retval
+}
+
+func sortedids(cons map[int]nm2qr.NetworkSetting, skipped map[int]ux.ConnectionError) []int {
+	keys := make([]int, 0, len(cons)+len(skipped))
 	for _, con := range cons {
-		if con.IsPsk {
+		// a WIFI: code cannot carry 802.1x credentials
+		if con.NeedsSecret() && !con.IsEap() {
 			keys = append(keys, con.DbusId)
 		}
 	}
+	for id := range skipped {
+		keys = append(keys, id)
+	}
 	sort.Ints(keys)
 	return keys
 }
 
+// rowtext formats a connection for the network list, connections whose
+// secret is blocked are marked.
+func rowtext(ns nm2qr.NetworkSetting) string {
+	s := fmt.Sprintf("[%d] %s (%s)", ns.DbusId, ns.Id, ns.Ssid)
+	if ns.IsWireGuard() {
+		s = fmt.Sprintf("[%d] %s (WireGuard)", ns.DbusId, ns.Id)
+	}
+	if ns.PermissionDenied {
+		s += " [secret unavailable](fg:red)"
+	}
+	return s
+}
+
+// skippedtext formats a connection which could not be read, it is greyed
+// out in the network list.
+func skippedtext(f ux.ConnectionError) string {
+	name := f.Id
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("[%d] [%s (skipped)](fg:white)", f.DbusId, name)
+}
+
 func main() {
-	dbusConnection, err := dbus.SystemBus()
+	var timeout time.Duration
+	var backendSpec string
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "give up on NetworkManager after this time")
+	flag.StringVar(&backendSpec, "backend", "networkmanager", "where connections are read from (networkmanager, replay:FILE, mobileconfig:FILE, wlanprofile:FILE, onc:FILE, netplan:FILE, hostapd:FILE, openwrt:FILE)")
+	flag.Parse()
+
+	backend, err := ux.OpenBackend(backendSpec)
 	if err != nil {
-		log.Fatalf("couldn't connect to system dbus: %v", err)
+		log.Fatalf("couldn't open backend: %v", err)
 	}
 	if err := ui.Init(); err != nil {
 		log.Fatalf("failed to initialize termui: %v", err)
 	}
 	defer ui.Close()
 
-	cons, err := ux.AllConnections(dbusConnection)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	cons, err := ux.AllConnectionsFrom(ctx, backend)
+	cancel()
 	if err != nil {
 		log.Fatalf("couldn't obtain connections: %v", err)
 	}
-	conmap := dbusmap(cons)
-	sortedkeys := sortedids(conmap)
+	conmap := dbusmap(cons.Settings)
+	skipped := skippedmap(cons.Errors)
+	sortedkeys := sortedids(conmap, skipped)
 
 	networklist := widgets.NewList()
 	networklist.Title = "known connections"
-	networklist.Rows = make([]string, 0, len(cons))
+	networklist.Rows = make([]string, 0, len(sortedkeys))
 
 	for _, id := range sortedkeys {
-		s := fmt.Sprintf("[%d] %s (%s)", id, conmap[id].Id, conmap[id].Ssid)
-		networklist.Rows = append(networklist.Rows, s)
+		if f, isSkipped := skipped[id]; isSkipped {
+			networklist.Rows = append(networklist.Rows, skippedtext(f))
+		} else {
+			networklist.Rows = append(networklist.Rows, rowtext(conmap[id]))
+		}
 	}
 	networklist.TextStyle = ui.NewStyle(ui.ColorCyan)
 	networklist.BorderStyle = ui.NewStyle(ui.ColorBlack, ui.ColorWhite)
@@ -97,18 +146,59 @@ func main() {
 
 	ui.Render(networklist, code)
 
+	var dialog *passwordDialog
+	// secrets are only fetched for selected connections, and only once
+	fetched := make(map[int]bool)
+	fetchErrors := make(map[int]error)
 	previousKey := ""
 	uiEvents := ui.PollEvents()
 	for {
 		e := <-uiEvents
-		getqr := func() (qrcode.QRCode, string) {
-			sel := networklist.SelectedRow
-			id := sortedkeys[sel]
-			qr, err := nm2qr.QRNetworkCode(conmap[id])
+		getqr := func(ns nm2qr.NetworkSetting) (qrcode.QRCode, string) {
+			qr, err := nm2qr.QRNetworkCode(ns)
 			if nil != err {
 				log.Fatalf("something went wrong in qr code generation, %v", err)
 			}
-			return qr, conmap[id].Id
+			return qr, ns.Id
+		}
+		perform := func(action string, ns nm2qr.NetworkSetting) {
+			switch action {
+			case "<Enter>":
+				qr, title := getqr(ns)
+				qrcode := qr.ToSmallString(false)
+				rows := strings.Split(qrcode, "\n")
+				rrows := make([][]string, 0, len(rows))
+				for _, row := range rows {
+					rrows = append(rrows, []string{row})
+				}
+
+				code.Rows = rrows
+				code.Title = title
+				code.TextStyle = ui.NewStyle(ui.ColorWhite, ui.ColorBlack)
+			case "s":
+				qr, title := getqr(ns)
+				fname := fmt.Sprintf("/tmp/nm2qr_%s.png", title)
+				qr.WriteFile(-5, fname)
+
+				code.Rows = [][]string{[]string{fmt.Sprintf("saved as %s", fname)}}
+				code.TextStyle = ui.NewStyle(ui.ColorBlue)
+			}
+		}
+		if dialog != nil {
+			switch dialog.handle(e) {
+			case dialogAccepted:
+				conmap[dialog.network.DbusId] = dialog.network
+				perform(dialog.action, dialog.network)
+				dialog = nil
+			case dialogCancelled:
+				dialog = nil
+			}
+			if dialog != nil {
+				ui.Render(networklist, code, dialog)
+			} else {
+				ui.Render(networklist, code)
+			}
+			continue
 		}
 		switch e.ID {
 		case "q", "<C-c>", "<Escape>":
@@ -133,29 +223,55 @@ func main() {
 			networklist.ScrollTop()
 		case "G", "<End>":
 			networklist.ScrollBottom()
-		case "<Enter>":
-			{
-				qr, title := getqr()
-				qrcode := qr.ToSmallString(false)
-				rows := strings.Split(qrcode, "\n")
-				rrows := make([][]string, 0, len(rows))
-				for _, row := range rows {
-					rrows = append(rrows, []string{row})
+		case "<Enter>", "s":
+			id := sortedkeys[networklist.SelectedRow]
+			if f, isSkipped := skipped[id]; isSkipped {
+				code.Rows = [][]string{[]string{"connection skipped:"}}
+				for _, line := range strings.Split(f.Err.Error(), "\n") {
+					code.Rows = append(code.Rows, []string{line})
 				}
-
-				code.Rows = rrows
-				code.Title = title
-				code.TextStyle = ui.NewStyle(ui.ColorWhite, ui.ColorBlack)
+				code.Title = string(f.Path)
+				code.TextStyle = ui.NewStyle(ui.ColorWhite)
+				break
 			}
-		case "s":
-			{
-				qr, title := getqr()
-				fname := fmt.Sprintf("/tmp/nm2qr_%s.png", title)
-				qr.WriteFile(-5, fname)
-
-				code.Rows = [][]string{[]string{fmt.Sprintf("saved as %s", fname)}}
-				code.TextStyle = ui.NewStyle(ui.ColorBlue)
+			ns := conmap[id]
+			if ns.NeedsSecret() && ns.KeySource == nm2qr.SecretSourceNone && !fetched[id] {
+				fetched[id] = true
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				fetchErrors[id] = backend.FetchSecrets(ctx, &ns)
+				cancel()
+				conmap[id] = ns
+				networklist.Rows[networklist.SelectedRow] = rowtext(ns)
+			}
+			if ns.IsWireGuard() && ns.KeySource == nm2qr.SecretSourceNone {
+				// there is no point in typing a private key
+				code.Rows = [][]string{[]string{"private key unavailable:"}}
+				for _, line := range strings.Split(fmt.Sprint(fetchErrors[id]), "\n") {
+					code.Rows = append(code.Rows, []string{line})
+				}
+				if ns.PermissionDenied {
+					for _, line := range strings.Split(nm2qr.PolkitHint, "\n") {
+						code.Rows = append(code.Rows, []string{line})
+					}
+				}
+				code.Title = ns.Id
+				code.TextStyle = ui.NewStyle(ui.ColorRed)
+				break
+			}
+			if ns.IsPsk && ns.KeySource == nm2qr.SecretSourceNone {
+				if ns.PermissionDenied {
+					code.Rows = [][]string{}
+					for _, line := range strings.Split(nm2qr.PolkitHint, "\n") {
+						code.Rows = append(code.Rows, []string{line})
+					}
+					code.Title = ns.Id
+					code.TextStyle = ui.NewStyle(ui.ColorRed)
+				}
+				dialog = newPasswordDialog(ns, e.ID)
+				ui.Render(networklist, code, dialog)
+				continue
 			}
+			perform(e.ID, ns)
 		}
 
 		if previousKey == "g" {
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus"
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+// ErrUnknownBackend is returned by OpenBackend for unsupported backend
+// specifications.
+var ErrUnknownBackend = errors.New("unknown backend")
+
+// Backend is a source of connection settings.
+type Backend interface {
+	// ConnectionIDs lists the ids of all connections.
+	ConnectionIDs(ctx context.Context) ([]int, error)
+	// Settings retrieves a connection without its secrets.
+	Settings(ctx context.Context, id int) (nm2qr.NetworkSetting, error)
+	// FetchSecrets adds the secrets to a connection from Settings.
+	FetchSecrets(ctx context.Context, ns *nm2qr.NetworkSetting) error
+}
+
+// DBusBackend retrieves connections from NetworkManager.
+type DBusBackend struct {
+	Conn *dbus.Conn
+}
+
+func (b DBusBackend) ConnectionIDs(ctx context.Context) ([]int, error) {
+	return ConnectionIDsContext(ctx, b.Conn)
+}
+
+func (b DBusBackend) Settings(ctx context.Context, id int) (nm2qr.NetworkSetting, error) {
+	return nm2qr.GetSettingsContext(ctx, id, b.Conn)
+}
+
+func (b DBusBackend) FetchSecrets(ctx context.Context, ns *nm2qr.NetworkSetting) error {
+	return ns.FetchSecretsContext(ctx, b.Conn)
+}
+
+// ReplayBackend serves connections from a fixture recorded with
+// nm2qr.RecordConnection.
+type ReplayBackend struct {
+	connections map[int]nm2qr.RecordedConnection
+}
+
+// NewReplayBackend reads a fixture (JSON encoded nm2qr.Fixture).
+func NewReplayBackend(path string) (*ReplayBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var fixture nm2qr.Fixture
+	if err := json.NewDecoder(f).Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("reading fixture %s: %v", path, err)
+	}
+	b := &ReplayBackend{connections: make(map[int]nm2qr.RecordedConnection)}
+	for _, c := range fixture.Connections {
+		b.connections[c.DbusId] = c
+	}
+	return b, nil
+}
+
+func (b *ReplayBackend) ConnectionIDs(ctx context.Context) ([]int, error) {
+	ids := make([]int, 0, len(b.connections))
+	for id := range b.connections {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (b *ReplayBackend) Settings(ctx context.Context, id int) (nm2qr.NetworkSetting, error) {
+	c, found := b.connections[id]
+	if !found {
+		return nm2qr.NetworkSetting{DbusId: id}, fmt.Errorf("%w: %d not in fixture", ErrNotFound, id)
+	}
+	return c.NetworkSetting()
+}
+
+func (b *ReplayBackend) FetchSecrets(ctx context.Context, ns *nm2qr.NetworkSetting) error {
+	c, found := b.connections[ns.DbusId]
+	if !found {
+		return fmt.Errorf("%w: %d not in fixture", ErrNotFound, ns.DbusId)
+	}
+	return c.AddSecrets(ns)
+}
+
+// StaticBackend serves connections read from a configuration file, the
+// secrets are part of the connections already.
+type StaticBackend struct {
+	connections []nm2qr.NetworkSetting
+}
+
+// NewStaticBackend serves the given connections, they are numbered from 1
+// in the given order.
+func NewStaticBackend(connections []nm2qr.NetworkSetting) *StaticBackend {
+	b := &StaticBackend{connections: connections}
+	for i := range b.connections {
+		b.connections[i].DbusId = i + 1
+	}
+	return b
+}
+
+// openStaticBackend reads a configuration file with one of the nm2qr
+// parsers.
+func openStaticBackend(path string, parse func(io.Reader) ([]nm2qr.NetworkSetting, error)) (*StaticBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	connections, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return NewStaticBackend(connections), nil
+}
+
+func (b *StaticBackend) ConnectionIDs(ctx context.Context) ([]int, error) {
+	ids := make([]int, len(b.connections))
+	for i := range b.connections {
+		ids[i] = i + 1
+	}
+	return ids, nil
+}
+
+func (b *StaticBackend) Settings(ctx context.Context, id int) (nm2qr.NetworkSetting, error) {
+	if id < 1 || id > len(b.connections) {
+		return nm2qr.NetworkSetting{DbusId: id}, fmt.Errorf("%w: %d not in file", ErrNotFound, id)
+	}
+	ns := b.connections[id-1]
+	return ns, ns.CheckType()
+}
+
+func (b *StaticBackend) FetchSecrets(ctx context.Context, ns *nm2qr.NetworkSetting) error {
+	if ns.NeedsSecret() && !ns.IsEap() && ns.Key == "" {
+		return fmt.Errorf("%w: the file has no secret for %s", nm2qr.ErrNoSecret, ns.Id)
+	}
+	return nil
+}
+
+// OpenBackend creates a backend from a command line specification:
+//
+//	networkmanager     NetworkManager on the system bus (default)
+//	replay:FILE        a fixture recorded with the dump mode
+//	mobileconfig:FILE  the Wi-Fi payloads of an Apple configuration profile
+//	wlanprofile:FILE   a Windows WLAN profile
+//	onc:FILE           a ChromeOS Open Network Configuration
+//	netplan:FILE       the wifis of a netplan configuration
+//	hostapd:FILE       the networks of a hostapd.conf
+//	openwrt:FILE       the wifi-ifaces of an OpenWrt /etc/config/wireless
+func OpenBackend(spec string) (Backend, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch kind {
+	case "", "networkmanager":
+		conn, err := dbus.SystemBus()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't connect to system dbus: %v", err)
+		}
+		return DBusBackend{conn}, nil
+	case "replay":
+		return NewReplayBackend(arg)
+	case "mobileconfig":
+		return openStaticBackend(arg, nm2qr.ParseMobileconfig)
+	case "wlanprofile":
+		return openStaticBackend(arg, nm2qr.ParseWLANProfile)
+	case "onc":
+		return openStaticBackend(arg, nm2qr.ParseONC)
+	case "netplan":
+		return openStaticBackend(arg, nm2qr.ParseNetplan)
+	case "hostapd":
+		return openStaticBackend(arg, nm2qr.ParseHostapd)
+	case "openwrt":
+		return openStaticBackend(arg, nm2qr.ParseOpenWrtWireless)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, spec)
+}
+
+// NetworkSettingFrom retrieves a connection together with its secrets.
+func NetworkSettingFrom(ctx context.Context, b Backend, id int) (nm2qr.NetworkSetting, error) {
+	ns, err := b.Settings(ctx, id)
+	if err != nil {
+		return ns, err
+	}
+	err = b.FetchSecrets(ctx, &ns)
+	return ns, err
+}
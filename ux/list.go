@@ -19,9 +19,14 @@
 package ux
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/godbus/dbus"
 	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
@@ -29,6 +34,15 @@ import (
 	// fuzzy "github.com/schollz/closestmatch/levenshtein"
 )
 
+var (
+	// ErrNotFound is returned when no connection matches a name. It is the
+	// same error as nm2qr.ErrNotFound.
+	ErrNotFound = nm2qr.ErrNotFound
+	// ErrAmbiguousMatch is returned when a name matches several
+	// connections.
+	ErrAmbiguousMatch = errors.New("ambiguous match")
+)
+
 type outernode struct {
 	XMLName xml.Name    `xml:"node"`
 	Nodes   []innernode `xml:"node"`
@@ -40,11 +54,17 @@ type innernode struct {
 }
 
 func ConnectionIDs(conn *dbus.Conn) ([]int, error) {
+	return ConnectionIDsContext(context.Background(), conn)
+}
+
+// ConnectionIDsContext is ConnectionIDs with a context which bounds the dbus
+// call.
+func ConnectionIDsContext(ctx context.Context, conn *dbus.Conn) ([]int, error) {
 	obj := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager/Settings")
 
-	settings := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0)
+	settings := obj.CallWithContext(ctx, "org.freedesktop.DBus.Introspectable.Introspect", 0)
 	if e := settings.Err; nil != e {
-		fmt.Printf("ERROR: %v\n", e)
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", e)
 		return []int{}, e
 	}
 
@@ -53,14 +73,14 @@ func ConnectionIDs(conn *dbus.Conn) ([]int, error) {
 	var n outernode
 	xml.Unmarshal([]byte(introspection), &n)
 
-	fmt.Printf("Got %d connections\n", len(n.Nodes))
+	fmt.Fprintf(os.Stderr, "Got %d connections\n", len(n.Nodes))
 	retval := make([]int, len(n.Nodes))
 	errors := 0
 	for i, _ := range n.Nodes {
 		// n.Nodes[i].Id, _ = strconv.Atoi(n.Nodes[i].Name)
 		id, err := strconv.Atoi(n.Nodes[i].Name)
 		if err != nil {
-			fmt.Printf("Unexpeced connection setting id: %s\n", n.Nodes[i])
+			fmt.Fprintf(os.Stderr, "Unexpeced connection setting id: %s\n", n.Nodes[i])
 			errors += 1
 		} else {
 			retval[i-errors] = id
@@ -70,39 +90,196 @@ func ConnectionIDs(conn *dbus.Conn) ([]int, error) {
 	return retval[0 : len(n.Nodes)-errors], nil
 }
 
+// AllConnections retrieves the settings of all connections. Secrets are not
+// retrieved, use FetchSecrets on the connection which is actually needed.
 func AllConnections(conn *dbus.Conn) ([]nm2qr.NetworkSetting, error) {
-	ids, err := ConnectionIDs(conn)
+	cons, err := AllConnectionsContext(context.Background(), conn)
+	return cons.Settings, err
+}
+
+// ConnectionError records why the settings of a connection could not be
+// retrieved.
+type ConnectionError struct {
+	DbusId int
+	Path   dbus.ObjectPath
+	Id     string // empty if not even the connection name is known
+	Err    error
+}
+
+func (e ConnectionError) Error() string {
+	if e.Id == "" {
+		return fmt.Sprintf("connection %d: %v", e.DbusId, e.Err)
+	}
+	return fmt.Sprintf("connection %d (%s): %v", e.DbusId, e.Id, e.Err)
+}
+
+func (e ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// fetchWorkers bounds the number of concurrent GetSettings calls.
+const fetchWorkers = 8
+
+// Connections is the outcome of retrieving all connections: the settings
+// of the usable (Wi-Fi) connections, the connections of other types and the
+// reasons why the remaining ones were skipped.
+type Connections struct {
+	Settings []nm2qr.NetworkSetting
+	Others   []nm2qr.NetworkSetting // CheckType fails, only the connection block may be set
+	Errors   []ConnectionError
+}
+
+// AllConnectionsContext retrieves the settings of all connections
+// concurrently. Connections whose settings could not be retrieved (in time)
+// are reported in Errors, the others are returned nonetheless.
+// The error is only set when the list of connections is unavailable.
+func AllConnectionsContext(ctx context.Context, conn *dbus.Conn) (Connections, error) {
+	return AllConnectionsFrom(ctx, DBusBackend{conn})
+}
+
+// AllConnectionsFrom is AllConnectionsContext for any backend.
+func AllConnectionsFrom(ctx context.Context, b Backend) (Connections, error) {
+	ids, err := b.ConnectionIDs(ctx)
 
 	if err != nil {
-		return []nm2qr.NetworkSetting{}, err
+		return Connections{}, err
+	}
+
+	results := make([]nm2qr.NetworkSetting, len(ids))
+	errs := make([]error, len(ids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchWorkers && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = b.Settings(ctx, ids[i])
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	networks := make([]nm2qr.NetworkSetting, 0, len(ids))
-	for _, id := range ids {
-		networkSettings, err := nm2qr.GetNetworkSettings(id, conn)
-		if err == nil {
-			networks = append(networks, networkSettings)
+	retval := Connections{Settings: make([]nm2qr.NetworkSetting, 0, len(ids))}
+	for i, id := range ids {
+		if errs[i] == nil {
+			retval.Settings = append(retval.Settings, results[i])
+		} else if errors.Is(errs[i], nm2qr.ErrUnsupportedType) {
+			retval.Others = append(retval.Others, results[i])
+		} else {
+			retval.Errors = append(retval.Errors, ConnectionError{
+				DbusId: id,
+				Path:   results[i].DbusPath(),
+				Id:     results[i].Id,
+				Err:    errs[i],
+			})
 		}
 	}
-	return networks, nil
+	return retval, nil
 }
 
 func BestMatch(connectionName string, dbusConnection *dbus.Conn) (nm2qr.NetworkSetting, error) {
-	networks, err := AllConnections(dbusConnection)
+	return BestMatchContext(context.Background(), connectionName, dbusConnection)
+}
+
+// BestMatchContext is BestMatch with a context which bounds the dbus calls.
+func BestMatchContext(ctx context.Context, connectionName string, dbusConnection *dbus.Conn) (nm2qr.NetworkSetting, error) {
+	return BestMatchFrom(ctx, connectionName, DBusBackend{dbusConnection})
+}
+
+// BestMatchFrom is BestMatchContext for any backend.
+func BestMatchFrom(ctx context.Context, connectionName string, b Backend) (nm2qr.NetworkSetting, error) {
+	cons, err := AllConnectionsFrom(ctx, b)
 	if err != nil {
 		var retval nm2qr.NetworkSetting
 		return retval, err
 	}
+	// other types take part so that their names yield an explanation
+	// instead of a different connection
+	networks := append(cons.Settings, cons.Others...)
 	networkNames := make([]string, 0, 2*len(networks))
-	networkMaps := make(map[string]nm2qr.NetworkSetting)
+	networkMaps := make(map[string][]nm2qr.NetworkSetting)
 	for _, networkSettings := range networks {
 		networkNames = append(networkNames, networkSettings.Id)
-		networkNames = append(networkNames, string(networkSettings.Ssid))
-		networkMaps[string(networkSettings.Ssid)] = networkSettings
-		networkMaps[networkSettings.Id] = networkSettings
+		networkMaps[networkSettings.Id] = appendUnique(networkMaps[networkSettings.Id], networkSettings)
+		if len(networkSettings.Ssid) > 0 {
+			networkNames = append(networkNames, string(networkSettings.Ssid))
+			networkMaps[string(networkSettings.Ssid)] = appendUnique(networkMaps[string(networkSettings.Ssid)], networkSettings)
+		}
 	}
 	cm := fuzzy.New(networkNames, []int{2, 3, 4})
 	best := cm.Closest(connectionName)
-	networkSettings := networkMaps[best]
-	return networkSettings, nil
+	networkSettings, err := unique(best, networkMaps[best])
+	if err != nil {
+		return networkSettings, err
+	}
+	if err := networkSettings.CheckType(); err != nil {
+		return networkSettings, err
+	}
+	err = b.FetchSecrets(ctx, &networkSettings)
+	return networkSettings, err
+}
+
+// ExactMatch returns the connection whose name or SSID is exactly
+// connectionName.
+func ExactMatch(connectionName string, dbusConnection *dbus.Conn) (nm2qr.NetworkSetting, error) {
+	return ExactMatchContext(context.Background(), connectionName, dbusConnection)
+}
+
+// ExactMatchContext is ExactMatch with a context which bounds the dbus
+// calls.
+func ExactMatchContext(ctx context.Context, connectionName string, dbusConnection *dbus.Conn) (nm2qr.NetworkSetting, error) {
+	return ExactMatchFrom(ctx, connectionName, DBusBackend{dbusConnection})
+}
+
+// ExactMatchFrom is ExactMatchContext for any backend.
+func ExactMatchFrom(ctx context.Context, connectionName string, b Backend) (nm2qr.NetworkSetting, error) {
+	cons, err := AllConnectionsFrom(ctx, b)
+	if err != nil {
+		var retval nm2qr.NetworkSetting
+		return retval, err
+	}
+	networks := append(cons.Settings, cons.Others...)
+	var matches []nm2qr.NetworkSetting
+	for _, networkSettings := range networks {
+		if networkSettings.Id == connectionName || (len(networkSettings.Ssid) > 0 && string(networkSettings.Ssid) == connectionName) {
+			matches = appendUnique(matches, networkSettings)
+		}
+	}
+	networkSettings, err := unique(connectionName, matches)
+	if err != nil {
+		return networkSettings, err
+	}
+	if err := networkSettings.CheckType(); err != nil {
+		return networkSettings, err
+	}
+	err = b.FetchSecrets(ctx, &networkSettings)
+	return networkSettings, err
+}
+
+func appendUnique(networks []nm2qr.NetworkSetting, ns nm2qr.NetworkSetting) []nm2qr.NetworkSetting {
+	for _, n := range networks {
+		if n.DbusId == ns.DbusId {
+			return networks
+		}
+	}
+	return append(networks, ns)
+}
+
+func unique(connectionName string, matches []nm2qr.NetworkSetting) (nm2qr.NetworkSetting, error) {
+	switch len(matches) {
+	case 0:
+		return nm2qr.NetworkSetting{}, fmt.Errorf("%w: %q", ErrNotFound, connectionName)
+	case 1:
+		return matches[0], nil
+	}
+	candidates := make([]string, 0, len(matches))
+	for _, m := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s (%d)", m.Id, m.DbusId))
+	}
+	return nm2qr.NetworkSetting{}, fmt.Errorf("%w: %q matches %s", ErrAmbiguousMatch, connectionName, strings.Join(candidates, ", "))
 }
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ux
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/pseyfert/go-networkmanager-qrcode-generator/nmtest"
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+)
+
+func startFake(t *testing.T, connections map[int]*nmtest.Connection) *dbus.Conn {
+	t.Helper()
+	server, err := nmtest.Start(connections)
+	if errors.Is(err, nmtest.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func fakeConnections() map[int]*nmtest.Connection {
+	broken := nmtest.WifiPsk("broken", "uuid-3", "broken", "")
+	broken.SettingsError = nmtest.InvalidConnection("broken profile")
+	return map[int]*nmtest.Connection{
+		1: nmtest.WifiPsk("home", "uuid-1", "HomeNet", "homesecret"),
+		2: nmtest.WifiPsk("office", "uuid-2", "OfficeNet", "officesecret"),
+		3: broken,
+		4: nmtest.Ethernet("wired", "uuid-4"),
+	}
+}
+
+func TestConnectionIDs(t *testing.T) {
+	conn := startFake(t, fakeConnections())
+	ids, err := ConnectionIDs(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 4 {
+		t.Errorf("expected 4 connections, got %v", ids)
+	}
+
+	// the introspected ids are those NetworkManager lists
+	var paths []dbus.ObjectPath
+	obj := conn.Object("org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager/Settings")
+	if err := obj.Call("org.freedesktop.NetworkManager.Settings.ListConnections", 0).Store(&paths); err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[dbus.ObjectPath]bool)
+	for _, p := range paths {
+		listed[p] = true
+	}
+	for _, id := range ids {
+		if !listed[nm2qr.NetworkSetting{DbusId: id}.DbusPath()] {
+			t.Errorf("connection %d is not listed in %v", id, paths)
+		}
+	}
+	if len(paths) != len(ids) {
+		t.Errorf("listed %v, introspected %v", paths, ids)
+	}
+}
+
+func TestAllConnections(t *testing.T) {
+	conn := startFake(t, fakeConnections())
+	cons, err := AllConnectionsContext(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cons.Settings) != 2 {
+		t.Errorf("expected 2 usable connections, got %v", cons.Settings)
+	}
+	for _, ns := range cons.Settings {
+		if ns.Key != "" {
+			t.Errorf("listing must not fetch secrets, got key for %s", ns.Id)
+		}
+	}
+	if len(cons.Errors) != 1 || cons.Errors[0].DbusId != 3 || !errors.Is(cons.Errors[0], nm2qr.ErrNotFound) {
+		t.Errorf("expected the broken connection to be skipped, got %v", cons.Errors)
+	}
+	if len(cons.Others) != 1 || cons.Others[0].Id != "wired" || cons.Others[0].TypeName() != "ethernet" {
+		t.Errorf("expected the ethernet connection among the others, got %v", cons.Others)
+	}
+}
+
+func TestAllConnectionsTimeout(t *testing.T) {
+	slow := nmtest.WifiPsk("slow", "uuid-1", "SlowNet", "slowsecret")
+	slow.Delay = 2 * time.Second
+	conn := startFake(t, map[int]*nmtest.Connection{
+		1: slow,
+		2: nmtest.WifiPsk("fast", "uuid-2", "FastNet", "fastsecret"),
+	})
+	ids, err := ConnectionIDs(conn)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("could not list connections: %v %v", ids, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	cons, err := AllConnectionsContext(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cons.Settings) != 1 || cons.Settings[0].Id != "fast" {
+		t.Errorf("expected the fast connection only, got %v", cons.Settings)
+	}
+	if len(cons.Errors) != 1 || !errors.Is(cons.Errors[0], context.DeadlineExceeded) {
+		t.Errorf("expected the slow connection to time out, got %v", cons.Errors)
+	}
+}
+
+func TestBestMatch(t *testing.T) {
+	conn := startFake(t, fakeConnections())
+	ns, err := BestMatch("ofice", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Id != "office" {
+		t.Errorf("expected office, got %s", ns.Id)
+	}
+	if ns.Key != "officesecret" || ns.KeySource != nm2qr.SecretSourceNetworkManager {
+		t.Errorf("secret not fetched for best match: %q from %v", ns.Key, ns.KeySource)
+	}
+}
+
+func TestExactMatch(t *testing.T) {
+	connections := fakeConnections()
+	connections[5] = nmtest.WifiPsk("home (5GHz)", "uuid-5", "HomeNet", "homesecret")
+	conn := startFake(t, connections)
+
+	if _, err := ExactMatch("offic", conn); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := ExactMatch("HomeNet", conn); !errors.Is(err, ErrAmbiguousMatch) {
+		t.Errorf("expected ambiguous match, got %v", err)
+	}
+	ns, err := ExactMatch("home", conn)
+	if err != nil || ns.DbusId != 1 {
+		t.Errorf("expected connection 1, got %v %v", ns.DbusId, err)
+	}
+	_, err = ExactMatch("wired", conn)
+	if !errors.Is(err, nm2qr.ErrUnsupportedType) || err.Error() != "connection wired is an ethernet connection, no QR code available" {
+		t.Errorf("expected the ethernet connection to be unsupported, got %v", err)
+	}
+}
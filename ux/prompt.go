@@ -0,0 +1,55 @@
+/*
+ * Copyright (C) 2019 Paul Seyfert
+ * Author: Paul Seyfert <pseyfert.mathphys@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ux
+
+import (
+	"fmt"
+	"os"
+
+	nm2qr "github.com/pseyfert/go-networkmanager-qrcode-generator/qrcode_for_nm_connection"
+	"golang.org/x/term"
+)
+
+const promptAttempts = 3
+
+// PromptKey asks on the terminal (without echo) for the key of a connection
+// whose secret could not be retrieved and stores it in ns.
+func PromptKey(ns *nm2qr.NetworkSetting) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("%w: cannot prompt for the key of %s, stdin is not a terminal", nm2qr.ErrNoSecret, ns.Id)
+	}
+	var err error
+	for attempt := 0; attempt < promptAttempts; attempt++ {
+		fmt.Fprintf(os.Stderr, "password for %s (SSID %s): ", ns.Id, ns.Ssid)
+		key, e := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if nil != e {
+			return fmt.Errorf("%w: reading key from terminal: %v", nm2qr.ErrNoSecret, e)
+		}
+		err = nm2qr.ValidateKey(ns.Sec, string(key))
+		if nil == err {
+			ns.Key = string(key)
+			ns.KeySource = nm2qr.SecretSourcePrompt
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	return err
+}